@@ -1,32 +1,65 @@
 package access_token
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
 	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
 )
 
-const accessTokenURL = "https://open.kuaishou.com/oauth2/access_token"
+// EndpointAccessToken 获取token的接口路径
+const EndpointAccessToken = "/oauth2/access_token"
+
+// tokenCacheMargin 缓存比token提前过期的时间
+const tokenCacheMargin = 1500 * time.Second
 
 // AccessToken 管理AccessToken 的基础接口
 type AccessToken interface {
 	GetCacheKey() string             // 获取缓存的key
 	SetCacheKey(key string)          // 设置缓存key
 	GetAccessToken() (string, error) // 获取token
+	// GetAccessTokenWithContext 获取token ctx取消或超时会中断刷新token的请求
+	GetAccessTokenWithContext(ctx context.Context) (string, error)
+	// Invalidate 删除缓存的token 下次获取时重新请求接口
+	Invalidate() error
+	// InvalidateToken 只有缓存中仍然是 token 时才删除 用于接口返回token无效后
+	// 避免删除其他请求或实例已经刷新好的新token
+	InvalidateToken(token string) error
+	// Refresh 忽略缓存 立即请求接口获取新的token并缓存
+	Refresh(ctx context.Context) (string, error)
+}
+
+// Observer 观察token的获取情况 用于统计缓存命中率与刷新次数
+type Observer interface {
+	ObserveTokenCache(hit bool)                            // 每次从缓存读取token后调用
+	ObserveTokenRefresh(duration time.Duration, err error) // 每次请求接口刷新token后调用
 }
 
 // DefaultAccessToken 默认的token管理类
 type DefaultAccessToken struct {
-	AppId               string      // app_id	string	是	小程序的 app_id
-	AppSecret           string      // app_secret	string	是	小程序的密钥
-	GrantType           string      // grant_type	string	是	固定值“client_credentials”
-	Cache               cache.Cache // 缓存组件
-	accessTokenLock     *sync.Mutex // 读写锁
-	accessTokenCacheKey string      // 缓存的key
+	AppId               string              // app_id	string	是	小程序的 app_id
+	AppSecret           string              // app_secret	string	是	小程序的密钥 设置了 SecretProvider 时不再使用
+	SecretProvider      util.SecretProvider // 每次获取token时读取密钥 支持不重启轮换 为nil时使用 AppSecret
+	GrantType           string              // grant_type	string	是	固定值“client_credentials”
+	Cache               cache.Cache         // 缓存组件
+	HTTPClient          util.Doer           // 请求token使用的http客户端 为空时使用 util.DefaultHTTPClient
+	BaseApiHost         string              // api基础地址 为空时使用 util.DefaultBaseApiHost
+	RetryPolicy         *util.RetryPolicy   // 获取token失败时的重试策略 为nil时不重试
+	Middlewares         []util.Middleware   // 获取token请求的中间件
+	Observer            Observer            // 监控 为nil时不统计
+	Tracer              util.Tracer         // 链路追踪 每次刷新token开启一个span 为nil时不追踪
+	Locker              cache.Locker        // 刷新token时使用的锁 多个实例共享缓存时只有一个实例请求接口 为nil时只在进程内加锁
+	accessTokenLock     chan struct{}       // 进程内刷新token的锁 等待时可以被ctx取消
+	accessTokenCacheKey string              // 缓存的key
+	stateLock           sync.Mutex          // 保护下面的后台刷新状态
+	lastToken           string              // 最近一次获取的token 刷新失败时在宽限期内使用
+	expiresAt           time.Time           // lastToken 的过期时间
+	refresher           *refresher          // 后台刷新 为nil时没有启动
 }
 
 // NewDefaultAccessToken 实例化默认的token管理类
@@ -40,7 +73,7 @@ func NewDefaultAccessToken(appId, appSecret string, cache cache.Cache) AccessTok
 		GrantType:           "client_credentials",
 		Cache:               cache,
 		accessTokenCacheKey: fmt.Sprintf("kuaishou_server_api_sdk_access_token_%s", appId),
-		accessTokenLock:     new(sync.Mutex),
+		accessTokenLock:     make(chan struct{}, 1),
 	}
 	return token
 }
@@ -57,34 +90,161 @@ func (dd *DefaultAccessToken) SetCacheKey(key string) {
 
 // GetAccessToken 获取token
 func (dd *DefaultAccessToken) GetAccessToken() (string, error) {
+	return dd.GetAccessTokenWithContext(context.Background())
+}
+
+// GetAccessTokenWithContext 获取token 缓存失效时使用ctx请求接口刷新
+// 缓存读取失败时直接返回错误 避免缓存故障时所有实例同时刷新token
+func (dd *DefaultAccessToken) GetAccessTokenWithContext(ctx context.Context) (string, error) {
 	// 先尝试从缓存中获取如果不存在就调用接口获取
-	if val := dd.Cache.Get(dd.GetCacheKey()); val != nil {
-		return val.(string), nil
+	token, err := dd.cachedToken(ctx)
+	if dd.Observer != nil {
+		dd.Observer.ObserveTokenCache(err == nil)
+	}
+	if !errors.Is(err, cache.ErrNotFound) {
+		return token, err
+	}
+	// 启动了后台刷新且旧token还可以使用时 直接返回旧token 由后台刷新
+	if stale, ok := dd.staleToken(); ok {
+		dd.wakeRefresher()
+		return stale, nil
 	}
 
 	// 加锁防止并发获取接口
-	dd.accessTokenLock.Lock()
-	defer dd.accessTokenLock.Unlock()
+	token, err = dd.withLock(ctx, func(ctx context.Context) (string, error) {
+		// 双捡防止重复获取 其他实例可能已经刷新过
+		if token, err := dd.cachedToken(ctx); !errors.Is(err, cache.ErrNotFound) {
+			return token, err
+		}
+		return dd.refresh(ctx)
+	})
+	if err != nil {
+		// 启动了后台刷新时 刷新失败先使用旧的token
+		if stale, ok := dd.staleToken(); ok {
+			return stale, nil
+		}
+	}
+	return token, err
+}
+
+// cachedToken 从缓存中读取token 不存在时返回 cache.ErrNotFound
+func (dd *DefaultAccessToken) cachedToken(ctx context.Context) (string, error) {
+	val, err := cache.NewContextCache(dd.Cache).GetWithContext(ctx, dd.GetCacheKey())
+	if err != nil {
+		return "", err
+	}
+	token, ok := val.(string)
+	if !ok || token == "" {
+		return "", cache.ErrNotFound
+	}
+	return token, nil
+}
+
+// cacheTTL 缓存中token的剩余有效期
+func (dd *DefaultAccessToken) cacheTTL(ctx context.Context) (time.Duration, error) {
+	return cache.NewContextCache(dd.Cache).TTL(ctx, dd.GetCacheKey())
+}
+
+// Invalidate 删除缓存的token 旧token不会再在宽限期内使用
+func (dd *DefaultAccessToken) Invalidate() error {
+	dd.setToken("", time.Time{})
+	return dd.Cache.Delete(dd.GetCacheKey())
+}
+
+// InvalidateToken 只有缓存中仍然是 token 时才删除缓存 已经被替换成新token时什么也不做
+// 缓存实现了 cache.CompareAndDeleter 时比较与删除是原子的 多级缓存以共享层的值为准
+func (dd *DefaultAccessToken) InvalidateToken(token string) error {
+	dd.stateLock.Lock()
+	if dd.lastToken == token {
+		dd.lastToken, dd.expiresAt = "", time.Time{}
+	}
+	dd.stateLock.Unlock()
+	_, err := cache.CompareAndDelete(context.Background(), cache.NewContextCache(dd.Cache), dd.GetCacheKey(), token)
+	return err
+}
 
-	// 双捡防止重复获取
-	if val := dd.Cache.Get(dd.GetCacheKey()); val != nil {
-		return val.(string), nil
+// Refresh 忽略缓存 立即获取新的token
+func (dd *DefaultAccessToken) Refresh(ctx context.Context) (string, error) {
+	return dd.withLock(ctx, func(ctx context.Context) (string, error) {
+		return dd.refresh(ctx)
+	})
+}
+
+// withLock 持有进程内的锁 以及设置了 Locker 时的跨进程锁 执行fn
+// 等待锁时ctx取消或超时直接返回 Locker 的锁有有效期时 fn使用的ctx在锁过期前取消 避免锁过期后其他实例同时刷新
+func (dd *DefaultAccessToken) withLock(ctx context.Context, fn func(ctx context.Context) (string, error)) (string, error) {
+	select {
+	case dd.accessTokenLock <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() {
+		<-dd.accessTokenLock
+	}()
+	if dd.Locker != nil {
+		unlock, err := dd.Locker.Lock(ctx, dd.GetCacheKey()+"_lock")
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			_ = unlock()
+		}()
+		if locker, ok := dd.Locker.(cache.LeaseLocker); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, locker.LeaseTTL())
+			defer cancel()
+		}
 	}
+	return fn(ctx)
+}
 
+// refresh 调用接口获取token并写入缓存 调用方需要持有锁
+func (dd *DefaultAccessToken) refresh(ctx context.Context) (string, error) {
 	// 开始调用接口获取token
-	reqAccessToken, err := GetTokenFromServer(accessTokenURL, dd.AppId, dd.AppSecret)
+	ctx, span := util.StartSpan(ctx, dd.Tracer, "kuaishou token refresh")
+	defer span.End()
+	span.SetAttribute("kuaishou.app_id", dd.AppId)
+	start := time.Now()
+	var reqAccessToken ResAccessToken
+	roundTrip := util.Chain(util.Transport(dd.HTTPClient), dd.Middlewares...)
+	err := dd.RetryPolicy.Do(ctx, EndpointAccessToken, true, func(attempt int) (err error) {
+		secret, err := dd.appSecret(ctx)
+		if err != nil {
+			return
+		}
+		reqAccessToken, err = getTokenFromServer(ctx, roundTrip, attempt, util.JoinURL(dd.BaseApiHost, EndpointAccessToken), dd.AppId, secret)
+		return
+	})
+	if dd.Observer != nil {
+		dd.Observer.ObserveTokenRefresh(time.Since(start), err)
+	}
 	if err != nil {
+		span.RecordError(err)
 		return "", err
 	}
 	// 设置缓存
-	expires := reqAccessToken.ExpiresIn - 1500
-	err = dd.Cache.Set(dd.GetCacheKey(), reqAccessToken.AccessToken, time.Duration(expires)*time.Second)
+	expiresIn := time.Duration(reqAccessToken.ExpiresIn) * time.Second
+	dd.setToken(reqAccessToken.AccessToken, start.Add(expiresIn))
+	ttl := expiresIn - tokenCacheMargin
+	if ttl <= 0 {
+		// token的有效期比提前过期的时间还短时 缓存有效期的一半
+		ttl = expiresIn / 2
+	}
+	err = cache.NewContextCache(dd.Cache).SetWithContext(ctx, dd.GetCacheKey(), reqAccessToken.AccessToken, ttl)
 	if err != nil {
 		return "", err
 	}
 	return reqAccessToken.AccessToken, nil
 }
 
+// appSecret 获取当前的密钥 没有设置 SecretProvider 时使用 AppSecret
+func (dd *DefaultAccessToken) appSecret(ctx context.Context) (string, error) {
+	if dd.SecretProvider == nil {
+		return dd.AppSecret, nil
+	}
+	return dd.SecretProvider.Secret(ctx)
+}
+
 // ResAccessToken 获取token的返回结构体
 type ResAccessToken struct {
 	Result      int    `json:"result,omitempty"`
@@ -96,18 +256,33 @@ type ResAccessToken struct {
 
 // GetTokenFromServer 从快手服务器获取token
 func GetTokenFromServer(apiUrl string, appId, appSecret string) (resAccessToken ResAccessToken, err error) {
-	params := url.Values{"app_id": []string{appId}, "app_secret": []string{appSecret}, "grant_type": []string{"client_credentials"}}
-	body, err := util.PostForm(apiUrl, params)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(body, &resAccessToken)
-	if err != nil {
-		return
-	}
-	if resAccessToken.Result != 1 {
-		err = fmt.Errorf("get access_token error : errcode=%v , errormsg=%v", resAccessToken.Result, resAccessToken.ErrorMsg)
-		return
+	return GetTokenFromServerWithContext(context.Background(), apiUrl, appId, appSecret)
+}
+
+// GetTokenFromServerWithContext 从快手服务器获取token ctx取消或超时会中断请求
+func GetTokenFromServerWithContext(ctx context.Context, apiUrl string, appId, appSecret string) (resAccessToken ResAccessToken, err error) {
+	return GetTokenFromServerWithClient(ctx, util.DefaultHTTPClient, apiUrl, appId, appSecret)
+}
+
+// GetTokenFromServerWithClient 使用指定的http客户端从快手服务器获取token
+func GetTokenFromServerWithClient(ctx context.Context, client util.Doer, apiUrl string, appId, appSecret string) (resAccessToken ResAccessToken, err error) {
+	return getTokenFromServer(ctx, util.Transport(client), 1, apiUrl, appId, appSecret)
+}
+
+// getTokenFromServer 通过 roundTrip 请求接口获取token
+func getTokenFromServer(ctx context.Context, roundTrip util.RoundTripFunc, attempt int, apiUrl string, appId, appSecret string) (resAccessToken ResAccessToken, err error) {
+	endpoint := apiUrl
+	if parse, parseErr := url.Parse(apiUrl); parseErr == nil {
+		endpoint = parse.Path
 	}
+	_, err = roundTrip(ctx, &util.Request{
+		Endpoint: endpoint,
+		URL:      apiUrl,
+		Form:     true,
+		Params:   map[string]interface{}{"app_id": appId, "app_secret": appSecret, "grant_type": "client_credentials"},
+		Header:   http.Header{},
+		Attempt:  attempt,
+		Out:      &resAccessToken,
+	})
 	return
 }
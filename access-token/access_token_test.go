@@ -0,0 +1,93 @@
+package access_token
+
+import (
+	"context"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDefaultAccessToken_InvalidateToken 测试两个实例使用两级缓存时 本地层的旧token不会误删其他实例刷新后的新token
+func TestDefaultAccessToken_InvalidateToken(t *testing.T) {
+	var refreshes int32
+	httpClient := doerFunc(func(req *http.Request) (*http.Response, error) {
+		count := atomic.AddInt32(&refreshes, 1)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf(`{"result":1,"access_token":"tok%d","expires_in":172800}`, count))),
+		}, nil
+	})
+	shared := cache.NewMemory()
+	pod := func() *DefaultAccessToken {
+		token := NewDefaultAccessToken("ks_test_app", "ks_test_secret", cache.NewTiered(cache.NewMemory(), shared, time.Minute)).(*DefaultAccessToken)
+		token.HTTPClient = httpClient
+		return token
+	}
+	a, b := pod(), pod()
+	for _, token := range []*DefaultAccessToken{a, b} {
+		if val, err := token.GetAccessToken(); err != nil || val != "tok1" {
+			t.Errorf("GetAccessToken got %s %v", val, err)
+			return
+		}
+	}
+	// a 发现tok1失效并刷新为tok2 b 的本地层仍然是tok1
+	_ = a.InvalidateToken("tok1")
+	if val, _ := a.GetAccessToken(); val != "tok2" {
+		t.Errorf("GetAccessToken got %s", val)
+		return
+	}
+	// b 随后也报告tok1失效 不能删除共享层中的tok2
+	if err := b.InvalidateToken("tok1"); err != nil {
+		t.Errorf("InvalidateToken got a error %s", err.Error())
+	}
+	if val, err := b.GetAccessToken(); err != nil || val != "tok2" || atomic.LoadInt32(&refreshes) != 2 {
+		t.Errorf("GetAccessToken got %s %v after %d refreshes", val, err, refreshes)
+	}
+}
+
+// TestDefaultAccessToken_StaleWhileRevalidate 测试启动后台刷新后缓存失效时 直接返回旧token 由后台刷新
+func TestDefaultAccessToken_StaleWhileRevalidate(t *testing.T) {
+	var refreshes int32
+	release := make(chan struct{})
+	token := NewDefaultAccessToken("ks_test_app", "ks_test_secret", cache.NewMemory()).(*DefaultAccessToken)
+	token.HTTPClient = doerFunc(func(req *http.Request) (*http.Response, error) {
+		count := atomic.AddInt32(&refreshes, 1)
+		if count > 1 {
+			// 后台刷新在请求返回之后才完成
+			<-release
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf(`{"result":1,"access_token":"tok%d","expires_in":172800}`, count))),
+		}, nil
+	})
+	if val, err := token.GetAccessToken(); err != nil || val != "tok1" {
+		t.Errorf("GetAccessToken got %s %v", val, err)
+		return
+	}
+	if err := token.StartRefresher(RefresherConfig{}); err != nil {
+		t.Errorf("StartRefresher got a error %s", err.Error())
+		return
+	}
+	defer token.Close()
+	_ = token.Cache.Delete(token.GetCacheKey())
+	if val, err := token.GetAccessToken(); err != nil || val != "tok1" {
+		t.Errorf("GetAccessToken should serve the stale token, got %s %v", val, err)
+	}
+	close(release)
+	for i := 0; i < 100; i++ {
+		if val, err := token.cachedToken(context.Background()); err == nil {
+			if val != "tok2" || atomic.LoadInt32(&refreshes) != 2 {
+				t.Errorf("cachedToken got %s after %d refreshes", val, refreshes)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("refresher should refresh the token in background")
+}
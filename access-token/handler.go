@@ -0,0 +1,131 @@
+package access_token
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// token服务的接口路径
+const (
+	EndpointRemoteToken      = "/token"            // 获取token 参数 app_id
+	EndpointRemoteInvalidate = "/token/invalidate" // 报告token失效 参数 app_id access_token 返回新的token force=1 时忽略 access_token 强制刷新
+)
+
+// 返回给调用方的错误码 result 为1时表示成功 与快手接口一致
+const (
+	remoteResultUnauthorized = 401
+	remoteResultNotFound     = 404
+	remoteResultFailed       = 502
+)
+
+// cacheTTLer 可以获取缓存中token剩余有效期的token管理 如 DefaultAccessToken
+type cacheTTLer interface {
+	cacheTTL(ctx context.Context) (time.Duration, error)
+}
+
+// Handler 把token管理以http接口的方式提供给内部服务 配合 RemoteAccessToken 使用
+// 持有 AppSecret 的只有token服务 调用方通过 Authorization: Bearer <key> 认证
+type Handler struct {
+	Lookup  func(appId string) (AccessToken, bool) // 根据 app_id 找到token管理
+	APIKeys []string                               // 允许访问的密钥 为空时拒绝所有请求
+	Timeout time.Duration                          // 单次获取token的超时时间 为0时使用10s
+}
+
+// NewHandler 根据 app_id 与token管理的对应关系实例化一个 Handler
+func NewHandler(tokens map[string]AccessToken, apiKeys ...string) *Handler {
+	return &Handler{
+		Lookup: func(appId string) (AccessToken, bool) {
+			token, ok := tokens[appId]
+			return token, ok
+		},
+		APIKeys: apiKeys,
+	}
+}
+
+// remoteResponse token服务的返回值 字段与快手获取token的接口一致
+type remoteResponse struct {
+	Result      int    `json:"result"`
+	ErrorMsg    string `json:"error_msg,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+}
+
+// ServeHTTP 处理获取token与报告token失效的请求
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.write(w, http.StatusUnauthorized, remoteResponse{Result: remoteResultUnauthorized, ErrorMsg: "unauthorized"})
+		return
+	}
+	if r.URL.Path != EndpointRemoteToken && r.URL.Path != EndpointRemoteInvalidate {
+		h.write(w, http.StatusNotFound, remoteResponse{Result: remoteResultNotFound, ErrorMsg: "endpoint not found"})
+		return
+	}
+	appId := r.FormValue("app_id")
+	token, ok := h.Lookup(appId)
+	if !ok {
+		h.write(w, http.StatusNotFound, remoteResponse{Result: remoteResultNotFound, ErrorMsg: "app not found"})
+		return
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	var accessToken string
+	var err error
+	switch {
+	case r.URL.Path == EndpointRemoteInvalidate && r.FormValue("force") == "1":
+		// 调用方不知道当前token时 直接刷新
+		accessToken, err = token.Refresh(ctx)
+	case r.URL.Path == EndpointRemoteInvalidate:
+		// 只有报告的token仍然是当前token时才失效 避免多个调用方重复刷新
+		if err = token.InvalidateToken(r.FormValue("access_token")); err == nil {
+			accessToken, err = token.GetAccessTokenWithContext(ctx)
+		}
+	default:
+		accessToken, err = token.GetAccessTokenWithContext(ctx)
+	}
+	if err != nil {
+		h.write(w, http.StatusBadGateway, remoteResponse{Result: remoteResultFailed, ErrorMsg: err.Error()})
+		return
+	}
+	// 返回缓存的剩余有效期 调用方的本地缓存不会比token服务的缓存更晚过期
+	res := remoteResponse{Result: 1, AccessToken: accessToken}
+	if token, ok := token.(cacheTTLer); ok {
+		if ttl, err := token.cacheTTL(ctx); err == nil && ttl >= time.Second {
+			res.ExpiresIn = int(ttl / time.Second)
+		}
+	}
+	h.write(w, http.StatusOK, res)
+}
+
+// authorized 校验调用方的密钥 必须以 Authorization: Bearer <key> 的形式传递
+func (h *Handler) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	key := strings.TrimPrefix(header, "Bearer ")
+	if key == "" {
+		return false
+	}
+	for _, apiKey := range h.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// write 输出json
+func (h *Handler) write(w http.ResponseWriter, statusCode int, res remoteResponse) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,184 @@
+package access_token
+
+import (
+	"context"
+	"errors"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// 后台刷新的默认配置
+const (
+	defaultRefreshAhead         = 30 * time.Minute
+	defaultRefreshRetryInterval = 30 * time.Second
+)
+
+// ErrRefresherStarted 后台刷新已经启动
+var ErrRefresherStarted = errors.New("access_token: refresher already started")
+
+// RefresherConfig 后台刷新token的配置
+type RefresherConfig struct {
+	Ahead         time.Duration // 在token过期前多久刷新 为0时使用30分钟 需要大于缓存提前过期的1500秒 否则请求中仍然会先刷新
+	Jitter        float64       // 随机抖动的比例 取值 0-1 0.2表示最多再提前Ahead的20% 用于错开多个实例的刷新时间
+	RetryInterval time.Duration // 刷新失败后多久重试 为0时使用30s
+	GracePeriod   time.Duration // 旧token在过期后还能继续使用的时间 为0时只使用到过期为止
+}
+
+// refresher 后台刷新的goroutine
+type refresher struct {
+	config RefresherConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+	wake   chan struct{} // 缓存失效时唤醒后台立即刷新
+}
+
+// StartRefresher 启动后台刷新 在token过期前主动获取新的token
+// 启动后缓存失效时 只要旧的token还在宽限期内就直接返回 同时唤醒后台刷新 请求不再等待刷新
+// 不再使用时调用 Close 停止
+func (dd *DefaultAccessToken) StartRefresher(config RefresherConfig) error {
+	if config.Ahead <= 0 {
+		config.Ahead = defaultRefreshAhead
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultRefreshRetryInterval
+	}
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	if dd.refresher != nil {
+		return ErrRefresherStarted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	dd.refresher = &refresher{config: config, cancel: cancel, done: make(chan struct{}), wake: make(chan struct{}, 1)}
+	go dd.runRefresher(ctx, dd.refresher)
+	return nil
+}
+
+// Close 停止后台刷新并等待正在进行的刷新结束 可以重复调用
+func (dd *DefaultAccessToken) Close() error {
+	dd.stateLock.Lock()
+	r := dd.refresher
+	dd.refresher = nil
+	dd.stateLock.Unlock()
+	if r != nil {
+		r.cancel()
+		<-r.done
+	}
+	return nil
+}
+
+// RefresherStarted 是否已经启动了后台刷新
+func (dd *DefaultAccessToken) RefresherStarted() bool {
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	return dd.refresher != nil
+}
+
+// ExpiresAt 当前token在快手服务器的过期时间 还没有获取过token时返回零值
+func (dd *DefaultAccessToken) ExpiresAt() time.Time {
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	return dd.expiresAt
+}
+
+// runRefresher 按照过期时间循环刷新token 直到ctx被取消
+func (dd *DefaultAccessToken) runRefresher(ctx context.Context, r *refresher) {
+	defer close(r.done)
+	dd.syncFromCache(ctx)
+	wait := dd.nextRefresh(r.config)
+	wake := r.wake
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+		_, err := dd.withLock(ctx, func(ctx context.Context) (string, error) {
+			// 其他实例已经刷新过时 直接使用缓存中的token 缓存已经失效时需要刷新
+			dd.syncFromCache(ctx)
+			_, err := dd.cachedToken(ctx)
+			if !errors.Is(err, cache.ErrNotFound) && time.Until(dd.ExpiresAt()) > time.Duration(float64(r.config.Ahead)*(1+math.Min(r.config.Jitter, 1))) {
+				return "", nil
+			}
+			return dd.refresh(ctx)
+		})
+		if err != nil {
+			// 刷新失败后等到重试时间 不被请求唤醒 避免接口故障时不停重试
+			wait, wake = r.config.RetryInterval, nil
+			continue
+		}
+		wait, wake = dd.nextRefresh(r.config), r.wake
+	}
+}
+
+// nextRefresh 距离下一次刷新的时间
+func (dd *DefaultAccessToken) nextRefresh(config RefresherConfig) time.Duration {
+	expiresAt := dd.ExpiresAt()
+	if expiresAt.IsZero() {
+		return 0
+	}
+	ahead := config.Ahead
+	if config.Jitter > 0 {
+		ahead += time.Duration(rand.Float64() * math.Min(config.Jitter, 1) * float64(config.Ahead))
+	}
+	if wait := time.Until(expiresAt) - ahead; wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// syncFromCache 根据缓存中的token与剩余时间更新过期时间 缓存不支持 TTL 时忽略
+func (dd *DefaultAccessToken) syncFromCache(ctx context.Context) {
+	token, err := dd.cachedToken(ctx)
+	if err != nil {
+		return
+	}
+	ttl, err := cache.NewContextCache(dd.Cache).TTL(ctx, dd.GetCacheKey())
+	if err != nil || ttl <= 0 {
+		return
+	}
+	expiresAt := time.Now().Add(ttl + tokenCacheMargin)
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	if expiresAt.After(dd.expiresAt) {
+		dd.lastToken, dd.expiresAt = token, expiresAt
+	}
+}
+
+// setToken 记录刷新得到的token与过期时间
+func (dd *DefaultAccessToken) setToken(token string, expiresAt time.Time) {
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	dd.lastToken, dd.expiresAt = token, expiresAt
+}
+
+// wakeRefresher 唤醒后台立即刷新 已经有待处理的唤醒时忽略
+func (dd *DefaultAccessToken) wakeRefresher() {
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	if dd.refresher == nil {
+		return
+	}
+	select {
+	case dd.refresher.wake <- struct{}{}:
+	default:
+	}
+}
+
+// staleToken 启动了后台刷新且旧token还在宽限期内时返回旧token
+func (dd *DefaultAccessToken) staleToken() (string, bool) {
+	dd.stateLock.Lock()
+	defer dd.stateLock.Unlock()
+	if dd.refresher == nil || dd.lastToken == "" {
+		return "", false
+	}
+	if time.Now().After(dd.expiresAt.Add(dd.refresher.config.GracePeriod)) {
+		return "", false
+	}
+	return dd.lastToken, true
+}
@@ -0,0 +1,177 @@
+package access_token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultRemoteCacheTTL 从token服务获取的token默认在本地缓存的时间
+const defaultRemoteCacheTTL = 5 * time.Minute
+
+// RemoteAccessToken 从 Handler 提供的token服务获取token 调用方不需要持有 AppSecret
+// 获取到的token在本地缓存 ServerURLs 中的服务依次尝试 全部失败时在旧token过期前继续使用旧token
+type RemoteAccessToken struct {
+	AppId      string        // 小程序的 app_id
+	ServerURLs []string      // token服务的地址 如 http://ks-token-server:8080 按顺序故障转移
+	APIKey     string        // 访问token服务的密钥
+	HTTPClient util.Doer     // 请求token服务使用的http客户端 为空时使用 util.DefaultHTTPClient
+	Cache      cache.Cache   // 本地缓存 为空时使用内存缓存
+	CacheTTL   time.Duration // 本地缓存的最长时间 为0时使用5分钟
+
+	lock                sync.Mutex
+	accessTokenCacheKey string
+	lastToken           string    // 最近一次获取的token 所有服务都失败时使用
+	expiresAt           time.Time // lastToken 的过期时间 token服务没有返回时为零值
+}
+
+// NewRemoteAccessToken 实例化一个从token服务获取token的管理类
+func NewRemoteAccessToken(appId string, serverURLs []string, apiKey string) *RemoteAccessToken {
+	return &RemoteAccessToken{
+		AppId:               appId,
+		ServerURLs:          serverURLs,
+		APIKey:              apiKey,
+		Cache:               cache.NewMemory(),
+		accessTokenCacheKey: fmt.Sprintf("kuaishou_server_api_sdk_remote_access_token_%s", appId),
+	}
+}
+
+var _ AccessToken = (*RemoteAccessToken)(nil)
+
+// GetCacheKey 获取缓存key
+func (rr *RemoteAccessToken) GetCacheKey() string {
+	return rr.accessTokenCacheKey
+}
+
+// SetCacheKey 设置缓存key
+func (rr *RemoteAccessToken) SetCacheKey(key string) {
+	rr.accessTokenCacheKey = key
+}
+
+// GetAccessToken 获取token
+func (rr *RemoteAccessToken) GetAccessToken() (string, error) {
+	return rr.GetAccessTokenWithContext(context.Background())
+}
+
+// GetAccessTokenWithContext 获取token 本地缓存失效时请求token服务
+func (rr *RemoteAccessToken) GetAccessTokenWithContext(ctx context.Context) (string, error) {
+	if val, ok := rr.Cache.Get(rr.GetCacheKey()).(string); ok && val != "" {
+		return val, nil
+	}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	if val, ok := rr.Cache.Get(rr.GetCacheKey()).(string); ok && val != "" {
+		return val, nil
+	}
+	return rr.fetch(ctx, EndpointRemoteToken, "", false)
+}
+
+// Invalidate 删除本地缓存 并通知token服务当前token已经失效
+func (rr *RemoteAccessToken) Invalidate() error {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	invalid := rr.lastToken
+	rr.lastToken, rr.expiresAt = "", time.Time{}
+	if err := rr.Cache.Delete(rr.GetCacheKey()); err != nil {
+		return err
+	}
+	if invalid == "" {
+		return nil
+	}
+	_, err := rr.fetch(context.Background(), EndpointRemoteInvalidate, invalid, false)
+	return err
+}
+
+// InvalidateToken 本地缓存仍然是 token 时删除缓存 并通知token服务该token已经失效
+// 本地缓存已经被替换成新token时说明其他请求已经处理过 直接返回
+func (rr *RemoteAccessToken) InvalidateToken(token string) error {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	if val, ok := rr.Cache.Get(rr.GetCacheKey()).(string); ok && val != "" && val != token {
+		return nil
+	}
+	if rr.lastToken == token {
+		rr.lastToken, rr.expiresAt = "", time.Time{}
+	}
+	if err := rr.Cache.Delete(rr.GetCacheKey()); err != nil {
+		return err
+	}
+	_, err := rr.fetch(context.Background(), EndpointRemoteInvalidate, token, false)
+	return err
+}
+
+// cacheTTL 本地缓存中token的剩余有效期
+func (rr *RemoteAccessToken) cacheTTL(ctx context.Context) (time.Duration, error) {
+	return cache.NewContextCache(rr.Cache).TTL(ctx, rr.GetCacheKey())
+}
+
+// Refresh 忽略本地缓存 让token服务刷新当前token并返回新的token
+// 本地没有token时不知道要失效哪个token 让token服务强制刷新
+func (rr *RemoteAccessToken) Refresh(ctx context.Context) (string, error) {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	current := rr.lastToken
+	if val, ok := rr.Cache.Get(rr.GetCacheKey()).(string); ok && val != "" {
+		current = val
+	}
+	return rr.fetch(ctx, EndpointRemoteInvalidate, current, current == "")
+}
+
+// fetch 依次请求token服务 成功后写入本地缓存 force为true时让token服务强制刷新 调用方需要持有锁
+func (rr *RemoteAccessToken) fetch(ctx context.Context, endpoint, invalid string, force bool) (string, error) {
+	if len(rr.ServerURLs) == 0 {
+		return "", errors.New("access_token: no token server")
+	}
+	params := map[string]interface{}{"app_id": rr.AppId}
+	if invalid != "" {
+		params["access_token"] = invalid
+	}
+	if force {
+		params["force"] = 1
+	}
+	var err error
+	for _, serverURL := range rr.ServerURLs {
+		var res ResAccessToken
+		_, err = util.Transport(rr.HTTPClient)(ctx, &util.Request{
+			Endpoint: endpoint,
+			URL:      util.JoinURL(serverURL, endpoint),
+			Form:     true,
+			Params:   params,
+			Header:   http.Header{"Authorization": []string{"Bearer " + rr.APIKey}},
+			Attempt:  1,
+			Out:      &res,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		ttl := rr.CacheTTL
+		if ttl <= 0 {
+			ttl = defaultRemoteCacheTTL
+		}
+		rr.lastToken, rr.expiresAt = res.AccessToken, time.Time{}
+		if res.ExpiresIn > 0 {
+			expiresIn := time.Duration(res.ExpiresIn) * time.Second
+			rr.expiresAt = time.Now().Add(expiresIn)
+			if expiresIn < ttl {
+				ttl = expiresIn
+			}
+		}
+		if err = rr.Cache.Set(rr.GetCacheKey(), res.AccessToken, ttl); err != nil {
+			return "", err
+		}
+		return res.AccessToken, nil
+	}
+	// 所有token服务都不可用时 旧token没有过期就继续使用
+	if rr.lastToken != "" && time.Now().Before(rr.expiresAt) {
+		return rr.lastToken, nil
+	}
+	return "", err
+}
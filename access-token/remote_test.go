@@ -0,0 +1,97 @@
+package access_token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// doerFunc 模拟快手获取token接口的http客户端
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestRemoteAccessToken 测试token服务的认证 本地缓存 失效通知与故障转移
+func TestRemoteAccessToken(t *testing.T) {
+	var refreshes int32
+	token := NewDefaultAccessToken("ks_test_app", "ks_test_secret", cache.NewMemory()).(*DefaultAccessToken)
+	token.HTTPClient = doerFunc(func(req *http.Request) (*http.Response, error) {
+		count := atomic.AddInt32(&refreshes, 1)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf(`{"result":1,"access_token":"token_%d","expires_in":172800}`, count))),
+		}, nil
+	})
+	server := httptest.NewServer(NewHandler(map[string]AccessToken{"ks_test_app": token}, "internal-key"))
+	// 第一个地址不可用 需要故障转移到第二个
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	unauthorized := NewRemoteAccessToken("ks_test_app", []string{server.URL}, "wrong-key")
+	var kuaiShouError *util.KuaiShouError
+	if _, err := unauthorized.GetAccessToken(); !errors.As(err, &kuaiShouError) || kuaiShouError.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GetAccessToken should be unauthorized, got %v", err)
+	}
+	// 没有 Bearer 前缀的密钥同样拒绝
+	req, _ := http.NewRequest(http.MethodPost, server.URL+EndpointRemoteToken+"?app_id=ks_test_app", nil)
+	req.Header.Set("Authorization", "internal-key")
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Handler should require the Bearer prefix, got %v %v", res, err)
+	} else {
+		_ = res.Body.Close()
+	}
+	unknown := NewRemoteAccessToken("ks_unknown_app", []string{server.URL}, "internal-key")
+	if _, err := unknown.GetAccessToken(); err == nil {
+		t.Errorf("GetAccessToken should fail for an unknown app")
+	}
+
+	remote := NewRemoteAccessToken("ks_test_app", []string{down.URL, server.URL}, "internal-key")
+	if val, err := remote.GetAccessToken(); err != nil || val != "token_1" {
+		t.Errorf("GetAccessToken got %s %v", val, err)
+		return
+	}
+	// 本地缓存命中时不再请求token服务
+	_, _ = remote.GetAccessToken()
+	if ttl, err := cache.NewContextCache(remote.Cache).TTL(context.Background(), remote.GetCacheKey()); err != nil || ttl > defaultRemoteCacheTTL {
+		t.Errorf("local cache ttl got %v %v", ttl, err)
+	}
+
+	// 报告token失效后token服务刷新 其他调用方再报告同一个旧token不会重复刷新
+	other := NewRemoteAccessToken("ks_test_app", []string{server.URL}, "internal-key")
+	_, _ = other.GetAccessToken()
+	if err := remote.Invalidate(); err != nil {
+		t.Errorf("Invalidate got a error %s", err.Error())
+	}
+	_ = other.Invalidate()
+	if val, _ := remote.GetAccessToken(); val != "token_2" || atomic.LoadInt32(&refreshes) != 2 {
+		t.Errorf("GetAccessToken got %s after %d refreshes", val, refreshes)
+	}
+
+	// 本地没有token时 Refresh 让token服务强制刷新 而不是返回服务端缓存的token
+	fresh := NewRemoteAccessToken("ks_test_app", []string{server.URL}, "internal-key")
+	if val, err := fresh.Refresh(context.Background()); err != nil || val != "token_3" || atomic.LoadInt32(&refreshes) != 3 {
+		t.Errorf("Refresh got %s %v after %d refreshes", val, err, refreshes)
+	}
+
+	// token服务全部不可用时 继续使用没有过期的旧token
+	server.Close()
+	_ = remote.Cache.Delete(remote.GetCacheKey())
+	if val, err := remote.GetAccessToken(); err != nil || val != "token_2" {
+		t.Errorf("GetAccessToken should serve the last token, got %s %v", val, err)
+	}
+	// 过期时间来自token服务缓存的剩余有效期 比token实际过期早
+	if expiresAt := remote.expiresAt; time.Until(expiresAt) < 47*time.Hour || time.Until(expiresAt) > 172800*time.Second-tokenCacheMargin {
+		t.Errorf("expiresAt got %v", expiresAt)
+	}
+}
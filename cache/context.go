@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound 值不存在或者已经过期
+	ErrNotFound = errors.New("cache: key not found")
+	// ErrNotInteger Incr 的值不是整数
+	ErrNotInteger = errors.New("cache: value is not an integer")
+	// ErrNotSupported 缓存不支持该操作
+	ErrNotSupported = errors.New("cache: operation not supported")
+)
+
+// NoExpiration TTL 返回该值表示永不过期
+const NoExpiration time.Duration = -1
+
+// ContextCache 支持ctx 返回错误以及原子操作的缓存接口
+// 网络缓存可以通过错误报告连接失败 SetNX 与 Incr 可以用来实现分布式锁与幂等控制
+type ContextCache interface {
+	// GetWithContext 获取缓存的值 不存在时返回 ErrNotFound
+	GetWithContext(ctx context.Context, key string) (interface{}, error)
+	// SetWithContext 设置一个值
+	SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error
+	// DeleteWithContext 删除一个值 不存在时不返回错误
+	DeleteWithContext(ctx context.Context, key string) error
+	// SetNX 值不存在时设置 返回是否设置成功
+	SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error)
+	// Incr 把整数值加上delta并返回新的值 不存在时从0开始且永不过期 已有的过期时间保持不变
+	Incr(ctx context.Context, key string, delta int64) (int64, error)
+	// TTL 剩余的有效期 永不过期时返回 NoExpiration 不存在时返回 ErrNotFound
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// CompareAndDeleter 值仍然等于old时才删除的缓存
+// 多级缓存以共享层的值为准 避免本地层的旧值误删其他实例写入的新值
+type CompareAndDeleter interface {
+	// CompareAndDelete 值仍然等于old时删除 返回是否删除
+	CompareAndDelete(ctx context.Context, key string, old interface{}) (bool, error)
+}
+
+// CompareAndDelete 值仍然等于old时删除 返回是否删除
+// c 没有实现 CompareAndDeleter 时先读取再删除 两步之间不是原子的
+func CompareAndDelete(ctx context.Context, c ContextCache, key string, old interface{}) (bool, error) {
+	if deleter, ok := c.(CompareAndDeleter); ok {
+		return deleter.CompareAndDelete(ctx, key, old)
+	}
+	val, err := c.GetWithContext(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil || !reflect.DeepEqual(val, old) {
+		return false, err
+	}
+	return true, c.DeleteWithContext(ctx, key)
+}
+
+// NewContextCache 把 Cache 转换为 ContextCache
+// c 已经实现了 ContextCache 时直接返回 否则使用适配器包装
+// 适配器的 SetNX 只在当前进程内是原子的 Incr 与 TTL 返回 ErrNotSupported
+func NewContextCache(c Cache) ContextCache {
+	if contextCache, ok := c.(ContextCache); ok {
+		return contextCache
+	}
+	return &contextAdapter{Cache: c}
+}
+
+// FromContextCache 把 ContextCache 转换为 Cache 用于只接受 Cache 的地方
+// c 已经实现了 Cache 时直接返回 否则使用适配器包装 读取失败时 Get 返回nil IsExist 返回false
+// 适配器同时实现了 ContextCache 与 CompareAndDeleter NewContextCache 会直接使用c的原子操作
+func FromContextCache(c ContextCache) Cache {
+	if plain, ok := c.(Cache); ok {
+		return plain
+	}
+	return &cacheAdapter{ContextCache: c}
+}
+
+// cacheAdapter 把 ContextCache 适配为 Cache
+type cacheAdapter struct {
+	ContextCache
+}
+
+// Get 获取缓存的值 不存在或者读取失败时返回nil
+func (a *cacheAdapter) Get(key string) interface{} {
+	val, err := a.GetWithContext(context.Background(), key)
+	if err != nil {
+		return nil
+	}
+	return val
+}
+
+// Set 设置一个值
+func (a *cacheAdapter) Set(key string, val interface{}, timeout time.Duration) error {
+	return a.SetWithContext(context.Background(), key, val, timeout)
+}
+
+// IsExist 判断值是否存在
+func (a *cacheAdapter) IsExist(key string) bool {
+	_, err := a.GetWithContext(context.Background(), key)
+	return err == nil
+}
+
+// Delete 删除一个值
+func (a *cacheAdapter) Delete(key string) error {
+	return a.DeleteWithContext(context.Background(), key)
+}
+
+// CompareAndDelete 值仍然等于old时删除
+func (a *cacheAdapter) CompareAndDelete(ctx context.Context, key string, old interface{}) (bool, error) {
+	return CompareAndDelete(ctx, a.ContextCache, key, old)
+}
+
+// adapterLock 所有适配器共用的锁 保证同一进程内 SetNX 的原子性
+var adapterLock sync.Mutex
+
+// contextAdapter 把 Cache 适配为 ContextCache
+type contextAdapter struct {
+	Cache
+}
+
+// GetWithContext 获取缓存的值
+func (a *contextAdapter) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	val := a.Get(key)
+	if val == nil {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+// SetWithContext 设置一个值
+func (a *contextAdapter) SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Set(key, val, timeout)
+}
+
+// DeleteWithContext 删除一个值
+func (a *contextAdapter) DeleteWithContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Delete(key)
+}
+
+// SetNX 值不存在时设置 只在当前进程内是原子的
+func (a *contextAdapter) SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	adapterLock.Lock()
+	defer adapterLock.Unlock()
+	if a.IsExist(key) {
+		return false, nil
+	}
+	return true, a.Set(key, val, timeout)
+}
+
+// Incr 普通的 Cache 无法保留过期时间 不支持
+func (a *contextAdapter) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+// TTL 普通的 Cache 无法获取过期时间 不支持
+func (a *contextAdapter) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, ErrNotSupported
+}
+
+// toInt64 把缓存中的数字转换为int64 json解析得到的float64必须是整数
+func toInt64(val interface{}) (int64, error) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+	}
+	return 0, ErrNotInteger
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// plainCache 只实现了 Cache 的缓存 用于测试适配器
+type plainCache struct {
+	Cache
+}
+
+// contextOnlyCache 只实现了 ContextCache 的缓存 用于测试 FromContextCache
+type contextOnlyCache struct {
+	ContextCache
+}
+
+// TestContextCache 测试内置缓存与适配器的 ContextCache 实现
+func TestContextCache(t *testing.T) {
+	mem := NewMemoryWithConfig(MemoryConfig{CleanupInterval: -1})
+	defer mem.Close()
+	file, err := NewFile(filepath.Join(t.TempDir(), "kuaishou.json"))
+	if err != nil {
+		t.Errorf("NewFile got a error %s", err.Error())
+		return
+	}
+	caches := map[string]Cache{
+		"memory":    mem,
+		"file":      file,
+		"namespace": NewNamespace(mem, "app:"),
+		"tiered":    NewTiered(NewMemory(), NewNamespace(file, "tiered:"), time.Minute),
+	}
+	ctx := context.Background()
+	for name, c := range caches {
+		cc := NewContextCache(c)
+		if _, ok := cc.(*contextAdapter); ok {
+			t.Errorf("%s should implement ContextCache natively", name)
+		}
+		if _, err := cc.GetWithContext(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s GetWithContext should return ErrNotFound, got %v", name, err)
+		}
+		if set, err := cc.SetNX(ctx, "lock", "a", time.Minute); !set || err != nil {
+			t.Errorf("%s SetNX got %v %v", name, set, err)
+		}
+		if set, _ := cc.SetNX(ctx, "lock", "b", time.Minute); set {
+			t.Errorf("%s SetNX should not overwrite an existing value", name)
+		}
+		if val, err := cc.GetWithContext(ctx, "lock"); val != "a" || err != nil {
+			t.Errorf("%s GetWithContext got %v %v", name, val, err)
+		}
+		if ttl, err := cc.TTL(ctx, "lock"); err != nil || ttl <= 0 || ttl > time.Minute {
+			t.Errorf("%s TTL got %v %v", name, ttl, err)
+		}
+		_, _ = cc.Incr(ctx, "counter", 2)
+		if val, err := cc.Incr(ctx, "counter", 3); val != 5 || err != nil {
+			t.Errorf("%s Incr got %v %v", name, val, err)
+		}
+		if ttl, _ := cc.TTL(ctx, "counter"); ttl != NoExpiration {
+			t.Errorf("%s TTL got %v want NoExpiration", name, ttl)
+		}
+		if _, err := cc.Incr(ctx, "lock", 1); !errors.Is(err, ErrNotInteger) {
+			t.Errorf("%s Incr should return ErrNotInteger, got %v", name, err)
+		}
+		_ = cc.DeleteWithContext(ctx, "lock")
+		if _, err := cc.TTL(ctx, "lock"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s TTL should return ErrNotFound after delete, got %v", name, err)
+		}
+	}
+
+	// 只实现了 Cache 的缓存通过适配器使用
+	adapter := NewContextCache(&plainCache{Cache: mem})
+	if set, err := adapter.SetNX(ctx, "adapter", "a", time.Minute); !set || err != nil {
+		t.Errorf("adapter SetNX got %v %v", set, err)
+	}
+	if set, _ := adapter.SetNX(ctx, "adapter", "b", time.Minute); set {
+		t.Errorf("adapter SetNX should not overwrite an existing value")
+	}
+	if _, err := adapter.Incr(ctx, "adapter", 1); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("adapter Incr should return ErrNotSupported, got %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := adapter.GetWithContext(canceled, "adapter"); !errors.Is(err, context.Canceled) {
+		t.Errorf("adapter GetWithContext should return context.Canceled, got %v", err)
+	}
+
+	// 只实现了 ContextCache 的缓存转换为 Cache 后 仍然可以使用原来的原子操作
+	plain := FromContextCache(&contextOnlyCache{ContextCache: mem})
+	if err := plain.Set("from", "value", time.Minute); err != nil || plain.Get("from") != "value" || !plain.IsExist("from") {
+		t.Errorf("FromContextCache Get got %v %v", plain.Get("from"), err)
+	}
+	if ttl, err := NewContextCache(plain).TTL(ctx, "from"); err != nil || ttl <= 0 {
+		t.Errorf("FromContextCache TTL got %v %v", ttl, err)
+	}
+	if deleted, err := CompareAndDelete(ctx, NewContextCache(plain), "from", "value"); !deleted || err != nil || plain.IsExist("from") {
+		t.Errorf("FromContextCache CompareAndDelete got %v %v", deleted, err)
+	}
+	if val := plain.Get("missing"); val != nil {
+		t.Errorf("FromContextCache Get got a missing value %v", val)
+	}
+}
@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileEntry 文件中存储的一条数据
+type fileEntry struct {
+	Value   json.RawMessage `json:"value"`
+	Expired time.Time       `json:"expired"` // 为零值时永不过期
+}
+
+// expired 判断是否已经过期
+func (entry fileEntry) expired(now time.Time) bool {
+	return !entry.Expired.IsZero() && entry.Expired.Before(now)
+}
+
+// File 使用本地文件持久化的缓存 适合经常重启的小型服务与命令行工具
+// 所有数据以json格式保存在一个文件中 写入时先写临时文件再重命名 保证文件总是完整的
+// 通过文件锁保证多个进程同时读写的安全 每次操作前都会重新读取文件
+// 值使用json序列化 读取时得到的是json解析后的值 如数字会变成float64
+type File struct {
+	Path string // 缓存文件的路径 同目录下会创建 Path+".lock" 作为锁文件
+	lock sync.Mutex
+}
+
+// NewFile 实例化一个文件缓存 目录不存在时自动创建 已有的缓存文件会被重新加载
+func NewFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file := &File{Path: path}
+	// 启动时读取一次 提前发现文件损坏或者没有权限
+	if err := file.withLock(false, func(map[string]fileEntry) (bool, error) {
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Get 获取缓存的值 不存在 过期或者出错时返回nil
+func (file *File) Get(key string) interface{} {
+	val, _ := file.GetWithContext(context.Background(), key)
+	return val
+}
+
+// Set 设置一个值
+func (file *File) Set(key string, val interface{}, timeout time.Duration) error {
+	return file.SetWithContext(context.Background(), key, val, timeout)
+}
+
+// IsExist 判断值是否存在
+func (file *File) IsExist(key string) bool {
+	_, err := file.TTL(context.Background(), key)
+	return err == nil
+}
+
+// Delete 删除一个值
+func (file *File) Delete(key string) error {
+	return file.DeleteWithContext(context.Background(), key)
+}
+
+// GetWithContext 获取缓存的值 不存在时返回 ErrNotFound
+func (file *File) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	var val interface{}
+	err := file.withLock(false, func(entries map[string]fileEntry) (bool, error) {
+		entry, ok := entries[key]
+		if !ok || entry.expired(time.Now()) {
+			return false, ErrNotFound
+		}
+		return false, json.Unmarshal(entry.Value, &val)
+	})
+	return val, err
+}
+
+// SetWithContext 设置一个值
+func (file *File) SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
+	marshal, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return file.withLock(true, func(entries map[string]fileEntry) (bool, error) {
+		entries[key] = fileEntry{Value: marshal, Expired: time.Now().Add(timeout)}
+		return true, nil
+	})
+}
+
+// DeleteWithContext 删除一个值
+func (file *File) DeleteWithContext(ctx context.Context, key string) error {
+	return file.withLock(true, func(entries map[string]fileEntry) (bool, error) {
+		if _, ok := entries[key]; !ok {
+			return false, nil
+		}
+		delete(entries, key)
+		return true, nil
+	})
+}
+
+// SetNX 值不存在时设置 返回是否设置成功 多个进程之间也是原子的
+func (file *File) SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error) {
+	marshal, err := json.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+	set := false
+	err = file.withLock(true, func(entries map[string]fileEntry) (bool, error) {
+		now := time.Now()
+		if entry, ok := entries[key]; ok && !entry.expired(now) {
+			return false, nil
+		}
+		entries[key] = fileEntry{Value: marshal, Expired: now.Add(timeout)}
+		set = true
+		return true, nil
+	})
+	return set, err
+}
+
+// Incr 把整数值加上delta并返回新的值 不存在时从0开始且永不过期
+func (file *File) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	var current int64
+	err := file.withLock(true, func(entries map[string]fileEntry) (bool, error) {
+		entry, ok := entries[key]
+		if ok && !entry.expired(time.Now()) {
+			if err := json.Unmarshal(entry.Value, &current); err != nil {
+				return false, ErrNotInteger
+			}
+		} else {
+			entry = fileEntry{}
+		}
+		current += delta
+		entry.Value, _ = json.Marshal(current)
+		entries[key] = entry
+		return true, nil
+	})
+	return current, err
+}
+
+// TTL 剩余的有效期 永不过期时返回 NoExpiration 不存在时返回 ErrNotFound
+func (file *File) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl := NoExpiration
+	err := file.withLock(false, func(entries map[string]fileEntry) (bool, error) {
+		now := time.Now()
+		entry, ok := entries[key]
+		if !ok || entry.expired(now) {
+			return false, ErrNotFound
+		}
+		if !entry.Expired.IsZero() {
+			ttl = entry.Expired.Sub(now)
+		}
+		return false, nil
+	})
+	return ttl, err
+}
+
+// withLock 加锁后读取文件 fn返回true时把修改后的数据写回文件
+// exclusive为false时加共享锁 不能写回
+func (file *File) withLock(exclusive bool, fn func(entries map[string]fileEntry) (bool, error)) error {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+	unlock, err := lockFile(file.Path+".lock", exclusive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unlock()
+	}()
+	entries, err := file.load()
+	if err != nil {
+		return err
+	}
+	changed, err := fn(entries)
+	if err != nil || !changed || !exclusive {
+		return err
+	}
+	return file.save(entries)
+}
+
+// load 读取文件中的数据 文件不存在时返回空数据
+func (file *File) load() (map[string]fileEntry, error) {
+	entries := map[string]fileEntry{}
+	content, err := ioutil.ReadFile(file.Path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return entries, nil
+	}
+	if err = json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// save 写入临时文件后重命名 顺便清理过期的数据
+func (file *File) save(entries map[string]fileEntry) error {
+	now := time.Now()
+	for key, entry := range entries {
+		if entry.expired(now) {
+			delete(entries, key)
+		}
+	}
+	marshal, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(file.Path), filepath.Base(file.Path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 重命名成功后临时文件已经不存在
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err = temp.Write(marshal); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Sync(); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file.Path)
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile 使用 flock 给锁文件加锁 同一台机器上的多个进程之间互斥
+func lockFile(name string, exclusive bool) (unlock func() error, err error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		if err = syscall.Flock(int(file.Fd()), how); err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return func() error {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return file.Close()
+	}, nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// fileLockStale 锁文件超过这个时间没有释放时 认为持有锁的进程已经退出
+const fileLockStale = 10 * time.Second
+
+// lockFile windows下没有 flock 通过独占创建锁文件实现互斥 共享锁同样按独占处理
+func lockFile(name string, exclusive bool) (unlock func() error, err error) {
+	for {
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_ = file.Close()
+			return func() error {
+				return os.Remove(name)
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, statErr := os.Stat(name); statErr == nil && time.Since(info.ModTime()) > fileLockStale {
+			_ = os.Remove(name)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
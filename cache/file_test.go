@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFile_GetSet 测试读写 过期 删除以及重启后重新加载
+func TestFile_GetSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "kuaishou.json")
+	file, err := NewFile(path)
+	if err != nil {
+		t.Errorf("NewFile got a error %s", err.Error())
+		return
+	}
+	if err = file.Set("key", "value", time.Minute); err != nil {
+		t.Errorf("Set got a error %s", err.Error())
+		return
+	}
+	_ = file.Set("number", 7200, time.Minute)
+	_ = file.Set("expired", "value", -time.Second)
+	if val := file.Get("expired"); val != nil || file.IsExist("expired") {
+		t.Errorf("Get got an expired value %v", val)
+	}
+	// 模拟重启 新实例读取同一个文件
+	reloaded, err := NewFile(path)
+	if err != nil {
+		t.Errorf("NewFile got a error %s", err.Error())
+		return
+	}
+	if val := reloaded.Get("key"); val != "value" || !reloaded.IsExist("key") {
+		t.Errorf("Get got a value %v after reload", val)
+	}
+	if val := reloaded.Get("number"); val != float64(7200) {
+		t.Errorf("Get got a value %v after reload", val)
+	}
+	_ = reloaded.Delete("key")
+	if val := file.Get("key"); val != nil {
+		t.Errorf("Get got a deleted value %v", val)
+	}
+}
+
+// TestFile_Concurrent 测试多个实例同时写入同一个文件时不会丢失数据
+func TestFile_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kuaishou.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		// 每个实例使用独立的文件句柄加锁 与多个进程的情况相同
+		file, err := NewFile(path)
+		if err != nil {
+			t.Errorf("NewFile got a error %s", err.Error())
+			return
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := file.Set(fmt.Sprintf("key%d-%d", i, j), j, time.Minute); err != nil {
+					t.Errorf("Set got a error %s", err.Error())
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	file, _ := NewFile(path)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 10; j++ {
+			if val := file.Get(fmt.Sprintf("key%d-%d", i, j)); val != float64(j) {
+				t.Errorf("Get key%d-%d got a value %v", i, j, val)
+			}
+		}
+	}
+}
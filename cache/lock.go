@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// 锁的默认配置
+const (
+	defaultLockTTL           = time.Minute
+	defaultLockRetryInterval = 50 * time.Millisecond
+)
+
+// Locker 锁接口 可以使用 CacheLocker 或者基于etcd zookeeper等自行实现
+type Locker interface {
+	// Lock 阻塞直到获取锁或者ctx结束 成功时返回释放锁的函数
+	Lock(ctx context.Context, key string) (unlock func() error, err error)
+}
+
+// LeaseLocker 锁在固定的有效期后自动释放的 Locker
+// 持有锁的一方需要在 LeaseTTL 内完成操作 否则其他实例可能同时获取到锁
+type LeaseLocker interface {
+	Locker
+	LeaseTTL() time.Duration // 获取锁后锁的有效期
+}
+
+// CacheLocker 基于 ContextCache.SetNX 实现的锁
+// 使用redis等共享缓存时可以在多个实例之间互斥 持有锁的实例崩溃后锁在TTL后自动释放
+// 释放时先确认锁仍然属于自己再删除 两步之间不是原子的 TTL需要大于持有锁的时间
+type CacheLocker struct {
+	Cache         ContextCache  // 存储锁的缓存
+	TTL           time.Duration // 锁的有效期 为0时使用1分钟 需要大于持有锁的操作最长的耗时
+	RetryInterval time.Duration // 获取锁失败后多久重试 为0时使用50ms
+}
+
+// NewLocker 实例化一个基于缓存的锁 c没有实现 ContextCache 时只能在进程内互斥
+func NewLocker(c Cache) *CacheLocker {
+	return &CacheLocker{Cache: NewContextCache(c)}
+}
+
+// LeaseTTL 获取锁后锁的有效期
+func (l *CacheLocker) LeaseTTL() time.Duration {
+	if l.TTL <= 0 {
+		return defaultLockTTL
+	}
+	return l.TTL
+}
+
+// Lock 获取锁
+func (l *CacheLocker) Lock(ctx context.Context, key string) (unlock func() error, err error) {
+	ttl, interval := l.LeaseTTL(), l.RetryInterval
+	if interval <= 0 {
+		interval = defaultLockRetryInterval
+	}
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return nil, err
+	}
+	owner := hex.EncodeToString(buf)
+	for {
+		set, err := l.Cache.SetNX(ctx, key, owner, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if set {
+			return func() error {
+				return l.unlock(key, owner)
+			}, nil
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// unlock 锁仍然属于owner时删除 锁已经过期被其他实例获取时不做处理
+func (l *CacheLocker) unlock(key, owner string) error {
+	_, err := CompareAndDelete(context.Background(), l.Cache, key, owner)
+	return err
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCacheLocker 测试互斥 ctx超时 过期自动释放 以及不会释放其他实例的锁
+func TestCacheLocker(t *testing.T) {
+	mem := NewMemoryWithConfig(MemoryConfig{CleanupInterval: -1})
+	defer mem.Close()
+	locker := &CacheLocker{Cache: mem, TTL: time.Minute, RetryInterval: time.Millisecond}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	holders, maxHolders := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := locker.Lock(ctx, "lock")
+			if err != nil {
+				t.Errorf("Lock got a error %s", err.Error())
+				return
+			}
+			lock.Lock()
+			holders++
+			if holders > maxHolders {
+				maxHolders = holders
+			}
+			lock.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			lock.Lock()
+			holders--
+			lock.Unlock()
+			_ = unlock()
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Errorf("Lock should be exclusive, got %d holders", maxHolders)
+	}
+
+	unlock, _ := locker.Lock(ctx, "lock")
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(timeout, "lock"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock should wait until the ctx deadline, got %v", err)
+	}
+	_ = unlock()
+
+	// 锁过期后被其他实例获取 旧的持有者释放时不影响新的持有者
+	short := &CacheLocker{Cache: mem, TTL: 5 * time.Millisecond, RetryInterval: time.Millisecond}
+	expired, _ := short.Lock(ctx, "short")
+	time.Sleep(10 * time.Millisecond)
+	current, err := short.Lock(ctx, "short")
+	if err != nil {
+		t.Errorf("Lock should succeed after the ttl, got %v", err)
+		return
+	}
+	_ = expired()
+	if !mem.IsExist("short") {
+		t.Errorf("unlock should not release a lock held by others")
+	}
+	_ = current()
+	if mem.IsExist("short") {
+		t.Errorf("unlock should release the lock")
+	}
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 默认的分片数与清理间隔
+const (
+	defaultMemoryShards          = 16
+	defaultMemoryCleanupInterval = time.Minute
+	minMemoryShardEntries        = 64 // 限制了最大条数时 每个分片至少容纳的条数
+)
+
+// MemoryConfig 内存缓存的配置
+type MemoryConfig struct {
+	Shards          int           // 分片数 分散锁竞争 小于1时使用16 设置了 MaxEntries 时按每个分片至少64条计算 最多16
+	MaxEntries      int           // 最多缓存的条数 为0时不限制 按分片分配 每个分片各自淘汰最久未使用的 需要严格的全局LRU时 Shards 设置为1
+	CleanupInterval time.Duration // 后台清理过期数据的间隔 为0时使用1分钟 小于0时不启动后台清理
+}
+
+// data 存储数据用的
+type data struct {
+	Key     string
+	Data    interface{}
+	Expired time.Time // 为零值时永不过期
+}
+
+// expired 判断是否已经过期
+func (d *data) expired(now time.Time) bool {
+	return !d.Expired.IsZero() && d.Expired.Before(now)
+}
+
+// shard 一个分片 使用链表记录访问顺序用于LRU淘汰
+type shard struct {
+	lock       sync.Mutex
+	data       map[string]*list.Element
+	order      *list.List // 最近访问的在前面
+	maxEntries int
+}
+
+// memory 内存缓存的实际实现 后台清理的goroutine只引用它
+type memory struct {
+	shards []*shard
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// Memory 实现一个并发安全的内存缓存
+// 按key分片加锁 后台定时清理过期数据 可以限制最大条数
+// 不再使用时调用 Close 停止后台清理
+type Memory struct {
+	*memory
+}
+
+// NewMemory 实例化一个内存缓存器
+func NewMemory() Cache {
+	return NewMemoryWithConfig(MemoryConfig{})
+}
+
+// NewMemoryWithConfig 根据配置实例化一个内存缓存器
+func NewMemoryWithConfig(config MemoryConfig) *Memory {
+	if config.Shards < 1 {
+		config.Shards = defaultMemoryShards
+		// 条数较少时减少分片 避免每个分片只能容纳很少的条数 淘汰时偏离全局LRU太多
+		if config.MaxEntries > 0 && config.MaxEntries/minMemoryShardEntries < config.Shards {
+			config.Shards = config.MaxEntries / minMemoryShardEntries
+		}
+		if config.Shards < 1 {
+			config.Shards = 1
+		}
+	}
+	// 每个分片至少容纳一条
+	if config.MaxEntries > 0 && config.Shards > config.MaxEntries {
+		config.Shards = config.MaxEntries
+	}
+	if config.CleanupInterval == 0 {
+		config.CleanupInterval = defaultMemoryCleanupInterval
+	}
+	mem := &memory{
+		shards: make([]*shard, config.Shards),
+		stop:   make(chan struct{}),
+	}
+	for i := range mem.shards {
+		// 余数分给前面的分片 所有分片的条数之和等于 MaxEntries
+		maxEntries := 0
+		if config.MaxEntries > 0 {
+			maxEntries = config.MaxEntries / config.Shards
+			if i < config.MaxEntries%config.Shards {
+				maxEntries++
+			}
+		}
+		mem.shards[i] = &shard{data: map[string]*list.Element{}, order: list.New(), maxEntries: maxEntries}
+	}
+	cache := &Memory{mem}
+	if config.CleanupInterval > 0 {
+		go mem.janitor(config.CleanupInterval)
+		// 使用方忘记调用 Close 时 在被回收前停止后台清理
+		runtime.SetFinalizer(cache, func(cache *Memory) {
+			cache.Close()
+		})
+	}
+	return cache
+}
+
+// Get 获取缓存的值
+func (mem *memory) Get(key string) interface{} {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	// 判断缓存是否过期
+	val := element.Value.(*data)
+	if val.expired(time.Now()) {
+		s.remove(element)
+		return nil
+	}
+	s.order.MoveToFront(element)
+	return val.Data
+}
+
+// Set 设置一个值
+func (mem *memory) Set(key string, val interface{}, timeout time.Duration) error {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.set(&data{Key: key, Data: val, Expired: time.Now().Add(timeout)})
+	return nil
+}
+
+// IsExist 判断值是否存在
+func (mem *memory) IsExist(key string) bool {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, ok := s.data[key]; ok {
+		return !element.Value.(*data).expired(time.Now())
+	}
+	return false
+}
+
+// Delete 删除一个值
+func (mem *memory) Delete(key string) error {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, ok := s.data[key]; ok {
+		s.remove(element)
+	}
+	return nil
+}
+
+// GetWithContext 获取缓存的值 不存在时返回 ErrNotFound
+func (mem *memory) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	val := mem.Get(key)
+	if val == nil {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+// SetWithContext 设置一个值
+func (mem *memory) SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
+	return mem.Set(key, val, timeout)
+}
+
+// DeleteWithContext 删除一个值
+func (mem *memory) DeleteWithContext(ctx context.Context, key string) error {
+	return mem.Delete(key)
+}
+
+// SetNX 值不存在时设置 返回是否设置成功
+func (mem *memory) SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error) {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if element, ok := s.data[key]; ok && !element.Value.(*data).expired(now) {
+		return false, nil
+	}
+	s.set(&data{Key: key, Data: val, Expired: now.Add(timeout)})
+	return true, nil
+}
+
+// CompareAndDelete 值仍然等于old时删除 返回是否删除
+func (mem *memory) CompareAndDelete(ctx context.Context, key string, old interface{}) (bool, error) {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, ok := s.data[key]
+	if !ok || element.Value.(*data).expired(time.Now()) || !reflect.DeepEqual(element.Value.(*data).Data, old) {
+		return false, nil
+	}
+	s.remove(element)
+	return true, nil
+}
+
+// Incr 把整数值加上delta并返回新的值 不存在时从0开始且永不过期
+func (mem *memory) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item := &data{Key: key}
+	if element, ok := s.data[key]; ok && !element.Value.(*data).expired(time.Now()) {
+		current, err := toInt64(element.Value.(*data).Data)
+		if err != nil {
+			return 0, err
+		}
+		item.Data, item.Expired = current+delta, element.Value.(*data).Expired
+	} else {
+		item.Data = delta
+	}
+	s.set(item)
+	return item.Data.(int64), nil
+}
+
+// TTL 剩余的有效期 永不过期时返回 NoExpiration 不存在时返回 ErrNotFound
+func (mem *memory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s := mem.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	element, ok := s.data[key]
+	if !ok || element.Value.(*data).expired(now) {
+		return 0, ErrNotFound
+	}
+	if expired := element.Value.(*data).Expired; !expired.IsZero() {
+		return expired.Sub(now), nil
+	}
+	return NoExpiration, nil
+}
+
+// Len 当前缓存的条数 包含尚未清理的过期数据
+func (mem *memory) Len() int {
+	total := 0
+	for _, s := range mem.shards {
+		s.lock.Lock()
+		total += len(s.data)
+		s.lock.Unlock()
+	}
+	return total
+}
+
+// Close 停止后台清理 可以重复调用
+func (mem *memory) Close() error {
+	mem.once.Do(func() {
+		close(mem.stop)
+	})
+	return nil
+}
+
+// DeleteExpired 删除所有过期的数据
+func (mem *memory) DeleteExpired() {
+	now := time.Now()
+	for _, s := range mem.shards {
+		s.lock.Lock()
+		for _, element := range s.data {
+			if element.Value.(*data).expired(now) {
+				s.remove(element)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+// janitor 定时清理过期数据 直到 Close
+func (mem *memory) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mem.DeleteExpired()
+		case <-mem.stop:
+			return
+		}
+	}
+}
+
+// shard 根据key找到对应的分片
+func (mem *memory) shard(key string) *shard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return mem.shards[hash.Sum32()%uint32(len(mem.shards))]
+}
+
+// set 写入或者覆盖一个元素 超过容量时淘汰最久未使用的 调用方需要持有锁
+func (s *shard) set(item *data) {
+	if element, ok := s.data[item.Key]; ok {
+		element.Value = item
+		s.order.MoveToFront(element)
+		return
+	}
+	s.data[item.Key] = s.order.PushFront(item)
+	if s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+	}
+}
+
+// remove 删除一个元素 调用方需要持有锁
+func (s *shard) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.data, element.Value.(*data).Key)
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMemory_GetSet 测试读写与过期
+func TestMemory_GetSet(t *testing.T) {
+	mem := NewMemoryWithConfig(MemoryConfig{CleanupInterval: -1})
+	defer mem.Close()
+	if err := mem.Set("key", "value", time.Minute); err != nil {
+		t.Errorf("Set got a error %s", err.Error())
+		return
+	}
+	if val := mem.Get("key"); val != "value" || !mem.IsExist("key") {
+		t.Errorf("Get got a value %v", val)
+	}
+	_ = mem.Set("expired", "value", -time.Second)
+	if val := mem.Get("expired"); val != nil || mem.IsExist("expired") {
+		t.Errorf("Get got an expired value %v", val)
+	}
+	_ = mem.Delete("key")
+	if val := mem.Get("key"); val != nil {
+		t.Errorf("Get got a deleted value %v", val)
+	}
+}
+
+// TestMemory_Janitor 测试后台清理过期数据 以及 Close 后停止清理
+func TestMemory_Janitor(t *testing.T) {
+	mem := NewMemoryWithConfig(MemoryConfig{CleanupInterval: 5 * time.Millisecond})
+	_ = mem.Set("expired", "value", time.Millisecond)
+	_ = mem.Set("alive", "value", time.Minute)
+	time.Sleep(30 * time.Millisecond)
+	if mem.Len() != 1 {
+		t.Errorf("Janitor should delete expired entries, got %d", mem.Len())
+	}
+	_ = mem.Close()
+	_ = mem.Close()
+	_ = mem.Set("expired", "value", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if mem.Len() != 2 {
+		t.Errorf("Janitor should stop after Close, got %d", mem.Len())
+	}
+}
+
+// TestMemory_LRU 测试超过最大条数时淘汰最久未使用的数据
+func TestMemory_LRU(t *testing.T) {
+	mem := NewMemoryWithConfig(MemoryConfig{Shards: 1, MaxEntries: 2, CleanupInterval: -1})
+	defer mem.Close()
+	_ = mem.Set("a", 1, time.Minute)
+	_ = mem.Set("b", 2, time.Minute)
+	// 访问a之后 b成为最久未使用的
+	_ = mem.Get("a")
+	_ = mem.Set("c", 3, time.Minute)
+	if mem.IsExist("b") || !mem.IsExist("a") || !mem.IsExist("c") || mem.Len() != 2 {
+		t.Errorf("LRU got a=%v b=%v c=%v", mem.Get("a"), mem.Get("b"), mem.Get("c"))
+	}
+}
+
+// TestMemory_MaxEntries 测试分片时缓存的总条数不超过 MaxEntries 默认分片数根据 MaxEntries 计算
+func TestMemory_MaxEntries(t *testing.T) {
+	for _, config := range []MemoryConfig{
+		{MaxEntries: 17, Shards: 16, CleanupInterval: -1},
+		{MaxEntries: 10, Shards: 32, CleanupInterval: -1},
+		{MaxEntries: 1000, CleanupInterval: -1},
+	} {
+		mem := NewMemoryWithConfig(config)
+		for i := 0; i < 5000; i++ {
+			_ = mem.Set(fmt.Sprintf("key%d", i), i, time.Minute)
+		}
+		if mem.Len() > config.MaxEntries {
+			t.Errorf("MaxEntries %d Shards %d got %d entries", config.MaxEntries, config.Shards, mem.Len())
+		}
+		_ = mem.Close()
+	}
+	// 条数较少时只使用一个分片 淘汰顺序与全局LRU一致
+	mem := NewMemoryWithConfig(MemoryConfig{MaxEntries: 100, CleanupInterval: -1})
+	defer mem.Close()
+	if len(mem.shards) != 1 {
+		t.Errorf("MaxEntries 100 got %d shards", len(mem.shards))
+	}
+	large := NewMemoryWithConfig(MemoryConfig{MaxEntries: 10000, CleanupInterval: -1})
+	defer large.Close()
+	if len(large.shards) != defaultMemoryShards {
+		t.Errorf("MaxEntries 10000 got %d shards", len(large.shards))
+	}
+}
+
+// TestMemory_Concurrent 并发读写 配合 go test -race 检查数据竞争
+func TestMemory_Concurrent(t *testing.T) {
+	mem := NewMemoryWithConfig(MemoryConfig{MaxEntries: 64, CleanupInterval: time.Millisecond})
+	defer mem.Close()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				key := fmt.Sprintf("key_%d", j%100)
+				_ = mem.Set(key, i, time.Duration(j%3)*time.Millisecond)
+				_ = mem.Get(key)
+				_ = mem.IsExist(key)
+				if j%10 == 0 {
+					_ = mem.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if mem.Len() > 64 {
+		t.Errorf("MaxEntries should bound the cache, got %d", mem.Len())
+	}
+}
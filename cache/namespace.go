@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Namespace 给缓存的key统一加上前缀
+// 多个小程序共享同一个缓存时 用于隔离各自的key
+type Namespace struct {
+	Cache  Cache  // 实际存储数据的缓存
+	Prefix string // key的前缀
+}
+
+// NewNamespace 实例化一个带前缀的缓存
+func NewNamespace(cache Cache, prefix string) Cache {
+	return &Namespace{
+		Cache:  cache,
+		Prefix: prefix,
+	}
+}
+
+// Get 获取缓存的值
+func (ns *Namespace) Get(key string) interface{} {
+	return ns.Cache.Get(ns.Prefix + key)
+}
+
+// Set 设置一个值
+func (ns *Namespace) Set(key string, val interface{}, timeout time.Duration) error {
+	return ns.Cache.Set(ns.Prefix+key, val, timeout)
+}
+
+// IsExist 判断值是否存在
+func (ns *Namespace) IsExist(key string) bool {
+	return ns.Cache.IsExist(ns.Prefix + key)
+}
+
+// Delete 删除一个值
+func (ns *Namespace) Delete(key string) error {
+	return ns.Cache.Delete(ns.Prefix + key)
+}
+
+// GetWithContext 获取缓存的值 底层缓存没有实现 ContextCache 时使用适配器
+func (ns *Namespace) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	return NewContextCache(ns.Cache).GetWithContext(ctx, ns.Prefix+key)
+}
+
+// SetWithContext 设置一个值
+func (ns *Namespace) SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
+	return NewContextCache(ns.Cache).SetWithContext(ctx, ns.Prefix+key, val, timeout)
+}
+
+// DeleteWithContext 删除一个值
+func (ns *Namespace) DeleteWithContext(ctx context.Context, key string) error {
+	return NewContextCache(ns.Cache).DeleteWithContext(ctx, ns.Prefix+key)
+}
+
+// SetNX 值不存在时设置 返回是否设置成功
+func (ns *Namespace) SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error) {
+	return NewContextCache(ns.Cache).SetNX(ctx, ns.Prefix+key, val, timeout)
+}
+
+// Incr 把整数值加上delta并返回新的值
+func (ns *Namespace) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return NewContextCache(ns.Cache).Incr(ctx, ns.Prefix+key, delta)
+}
+
+// TTL 剩余的有效期
+func (ns *Namespace) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return NewContextCache(ns.Cache).TTL(ctx, ns.Prefix+key)
+}
+
+// CompareAndDelete 值仍然等于old时删除 返回是否删除
+func (ns *Namespace) CompareAndDelete(ctx context.Context, key string, old interface{}) (bool, error) {
+	return CompareAndDelete(ctx, NewContextCache(ns.Cache), ns.Prefix+key, old)
+}
@@ -0,0 +1,228 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrNil redis 返回了空值
+var ErrNil = errors.New("redis: nil")
+
+// Error redis 返回的错误
+type Error string
+
+// Error 实现error接口
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Options 连接redis的参数
+type Options struct {
+	Addr        string        // 地址 如 127.0.0.1:6379
+	Password    string        // 密码 为空时不认证
+	DB          int           // 数据库编号
+	Prefix      string        // 缓存key的前缀
+	DialTimeout time.Duration // 连接超时 为0时使用5s
+	ReadTimeout time.Duration // 读写超时 ctx没有期限时使用 为0时使用3s
+	PoolSize    int           // 最多保留的空闲连接数 为0时使用10
+}
+
+// conn 一个redis连接
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Client 只实现了 RESP 协议基础部分的最小redis客户端 并发安全
+type Client struct {
+	options Options
+	idle    chan *conn
+}
+
+// NewClient 实例化一个redis客户端 连接在第一次使用时建立
+func NewClient(options Options) *Client {
+	if options.DialTimeout == 0 {
+		options.DialTimeout = 5 * time.Second
+	}
+	if options.ReadTimeout == 0 {
+		options.ReadTimeout = 3 * time.Second
+	}
+	if options.PoolSize == 0 {
+		options.PoolSize = 10
+	}
+	return &Client{options: options, idle: make(chan *conn, options.PoolSize)}
+}
+
+// Do 执行一条命令 返回值为 string int64 []interface{} 或者nil
+// 空闲连接可能已经被redis关闭 如redis重启或者空闲超时 此时换一个新连接重试一次
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cn, pooled, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, cn, args...)
+	if err != nil && pooled && isClosedConn(err) {
+		if cn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+		reply, err = c.do(ctx, cn, args...)
+	}
+	return reply, err
+}
+
+// do 在连接上执行命令并归还连接
+func (c *Client) do(ctx context.Context, cn *conn, args ...interface{}) (interface{}, error) {
+	reply, err := cn.do(ctx, c.options.ReadTimeout, args...)
+	// redis返回的错误不影响连接 其他错误时连接可能处于不确定的状态 直接关闭
+	var redisError Error
+	if err != nil && !errors.As(err, &redisError) {
+		_ = cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// isClosedConn 连接在发送命令前已经被对方关闭 命令没有被执行 可以安全重试
+// 读到一半的返回值 io.ErrUnexpectedEOF 说明命令已经执行 不在此列
+func isClosedConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Close 关闭所有空闲连接
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// get 获取一个空闲连接 没有时新建 pooled表示是否是空闲连接
+func (c *Client) get(ctx context.Context) (cn *conn, pooled bool, err error) {
+	select {
+	case cn = <-c.idle:
+		return cn, true, nil
+	default:
+	}
+	cn, err = c.dial(ctx)
+	return cn, false, err
+}
+
+// dial 新建一个连接 并完成认证与选择数据库
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.options.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.options.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if c.options.Password != "" {
+		if _, err = cn.do(ctx, c.options.ReadTimeout, "AUTH", c.options.Password); err != nil {
+			_ = cn.Close()
+			return nil, err
+		}
+	}
+	if c.options.DB != 0 {
+		if _, err = cn.do(ctx, c.options.ReadTimeout, "SELECT", c.options.DB); err != nil {
+			_ = cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put 归还连接 空闲连接已满时关闭
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+// do 在连接上发送命令并读取返回值
+func (cn *conn) do(ctx context.Context, timeout time.Duration, args ...interface{}) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := cn.Write(encodeCommand(args...)); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+
+// encodeCommand 把命令编码为 RESP 数组
+func encodeCommand(args ...interface{}) []byte {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		var val string
+		switch v := arg.(type) {
+		case string:
+			val = v
+		case []byte:
+			val = string(v)
+		default:
+			val = fmt.Sprint(v)
+		}
+		buf = append(buf, fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)...)
+	}
+	return buf
+}
+
+// readReply 读取一个 RESP 返回值
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, size)
+		for i := range replies {
+			if replies[i], err = readReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"strings"
+	"time"
+)
+
+// Redis 基于redis的 cache.Cache 实现 多个实例共享同一份access_token
+// 值使用json序列化 读取时得到的是json解析后的值 如数字会变成float64
+type Redis struct {
+	Client *Client // redis客户端
+	Prefix string  // key的前缀
+}
+
+// NewRedis 实例化一个redis缓存
+func NewRedis(options Options) *Redis {
+	return &Redis{
+		Client: NewClient(options),
+		Prefix: options.Prefix,
+	}
+}
+
+var _ cache.Cache = (*Redis)(nil)
+
+var _ cache.ContextCache = (*Redis)(nil)
+
+// Get 获取缓存的值 不存在或者出错时返回nil
+func (r *Redis) Get(key string) interface{} {
+	val, _ := r.GetWithContext(context.Background(), key)
+	return val
+}
+
+// Set 设置一个值 timeout小于等于0时视为已经过期 与内存缓存一致
+func (r *Redis) Set(key string, val interface{}, timeout time.Duration) error {
+	return r.SetWithContext(context.Background(), key, val, timeout)
+}
+
+// IsExist 判断值是否存在
+func (r *Redis) IsExist(key string) bool {
+	reply, err := r.Client.Do(context.Background(), "EXISTS", r.Prefix+key)
+	return err == nil && reply == int64(1)
+}
+
+// Delete 删除一个值
+func (r *Redis) Delete(key string) error {
+	return r.DeleteWithContext(context.Background(), key)
+}
+
+// GetWithContext 获取缓存的值 不存在时返回 cache.ErrNotFound
+func (r *Redis) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	reply, err := r.Client.Do(ctx, "GET", r.Prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, cache.ErrNotFound
+	}
+	var val interface{}
+	if err = json.Unmarshal([]byte(reply.(string)), &val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// SetWithContext 设置一个值 timeout小于等于0时视为已经过期 删除已有的值
+func (r *Redis) SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
+	_, err := r.set(ctx, key, val, timeout, false)
+	return err
+}
+
+// DeleteWithContext 删除一个值
+func (r *Redis) DeleteWithContext(ctx context.Context, key string) error {
+	_, err := r.Client.Do(ctx, "DEL", r.Prefix+key)
+	return err
+}
+
+// SetNX 值不存在时设置 返回是否设置成功
+func (r *Redis) SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error) {
+	return r.set(ctx, key, val, timeout, true)
+}
+
+// Incr 把整数值加上delta并返回新的值 不存在时从0开始
+func (r *Redis) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	reply, err := r.Client.Do(ctx, "INCRBY", r.Prefix+key, delta)
+	if err != nil {
+		if strings.Contains(err.Error(), "not an integer") {
+			return 0, cache.ErrNotInteger
+		}
+		return 0, err
+	}
+	return reply.(int64), nil
+}
+
+// TTL 剩余的有效期 永不过期时返回 cache.NoExpiration 不存在时返回 cache.ErrNotFound
+func (r *Redis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	reply, err := r.Client.Do(ctx, "PTTL", r.Prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	switch ms := reply.(int64); {
+	case ms == -2:
+		return 0, cache.ErrNotFound
+	case ms < 0:
+		return cache.NoExpiration, nil
+	default:
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
+
+// set 执行 SET 命令 nx为true时只在不存在时设置
+// timeout小于等于0时值立即过期 不写入redis 不足1毫秒的有效期向上取整为1毫秒
+func (r *Redis) set(ctx context.Context, key string, val interface{}, timeout time.Duration, nx bool) (bool, error) {
+	marshal, err := json.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+	if timeout <= 0 {
+		if nx {
+			reply, err := r.Client.Do(ctx, "EXISTS", r.Prefix+key)
+			return err == nil && reply == int64(0), err
+		}
+		_, err = r.Client.Do(ctx, "DEL", r.Prefix+key)
+		return err == nil, err
+	}
+	ms := int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	args := []interface{}{"SET", r.Prefix + key, marshal, "PX", ms}
+	if nx {
+		args = append(args, "NX")
+	}
+	reply, err := r.Client.Do(ctx, args...)
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// Close 关闭redis连接
+func (r *Redis) Close() error {
+	return r.Client.Close()
+}
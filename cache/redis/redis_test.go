@@ -0,0 +1,306 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer 进程内的假redis 只实现了测试用到的命令
+type fakeServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	password string
+	values   map[string]string
+	expires  map[string]time.Time
+	commands []string
+	conns    []net.Conn
+}
+
+// newFakeServer 启动一个假redis
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen got a error %s", err.Error())
+	}
+	s := &fakeServer{listener: listener, password: password, values: map[string]string{}, expires: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = listener.Close() })
+	return s
+}
+
+// closeConns 关闭所有已经建立的连接 模拟redis重启或者空闲超时
+func (s *fakeServer) closeConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+// serve 处理一个连接
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.lock.Lock()
+	s.conns = append(s.conns, conn)
+	s.lock.Unlock()
+	reader := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		if name == "AUTH" {
+			authed = len(args) == 2 && args[1] == s.password
+			if !authed {
+				_, _ = conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+		} else if !authed {
+			_, _ = conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		_, _ = conn.Write([]byte(s.exec(name, args[1:])))
+	}
+}
+
+// exec 执行命令并返回编码后的结果
+func (s *fakeServer) exec(name string, args []string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.commands = append(s.commands, strings.TrimSpace(name+" "+strings.Join(args, " ")))
+	for key, expire := range s.expires {
+		if time.Now().After(expire) {
+			delete(s.values, key)
+			delete(s.expires, key)
+		}
+	}
+	switch name {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		val, ok := s.values[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+	case "SET":
+		var expire time.Time
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				if _, ok := s.values[args[0]]; ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				i++
+				ms, _ := strconv.Atoi(args[i])
+				expire = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+		}
+		s.values[args[0]] = args[1]
+		delete(s.expires, args[0])
+		if !expire.IsZero() {
+			s.expires[args[0]] = expire
+		}
+		return "+OK\r\n"
+	case "INCRBY":
+		current, err := strconv.ParseInt(s.values[args[0]], 10, 64)
+		if _, ok := s.values[args[0]]; ok && err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		delta, _ := strconv.ParseInt(args[1], 10, 64)
+		s.values[args[0]] = strconv.FormatInt(current+delta, 10)
+		return fmt.Sprintf(":%d\r\n", current+delta)
+	case "PTTL":
+		if _, ok := s.values[args[0]]; !ok {
+			return ":-2\r\n"
+		}
+		if expire, ok := s.expires[args[0]]; ok {
+			return fmt.Sprintf(":%d\r\n", time.Until(expire).Milliseconds())
+		}
+		return ":-1\r\n"
+	case "EXISTS", "DEL":
+		count := 0
+		for _, key := range args {
+			if _, ok := s.values[key]; ok {
+				count++
+				if name == "DEL" {
+					delete(s.values, key)
+					delete(s.expires, key)
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", count)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", name)
+}
+
+// TestRedis_GetSet 测试读写 过期 前缀与删除
+func TestRedis_GetSet(t *testing.T) {
+	server := newFakeServer(t, "")
+	r := NewRedis(Options{Addr: server.listener.Addr().String(), Prefix: "ks:"})
+	defer r.Close()
+	if err := r.Set("token", "value", time.Minute); err != nil {
+		t.Errorf("Set got a error %s", err.Error())
+		return
+	}
+	if val := r.Get("token"); val != "value" || !r.IsExist("token") {
+		t.Errorf("Get got a value %v", val)
+	}
+	server.lock.Lock()
+	_, ok := server.values["ks:token"]
+	server.lock.Unlock()
+	if !ok {
+		t.Errorf("Set should use the prefix")
+	}
+	_ = r.Set("number", map[string]interface{}{"expires_in": 7200}, time.Minute)
+	if val, ok := r.Get("number").(map[string]interface{}); !ok || val["expires_in"] != float64(7200) {
+		t.Errorf("Get got a value %v", r.Get("number"))
+	}
+	_ = r.Set("expired", "value", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if val := r.Get("expired"); val != nil || r.IsExist("expired") {
+		t.Errorf("Get got an expired value %v", val)
+	}
+	// 有效期小于等于0时视为已经过期 删除已有的值 不足1毫秒时向上取整
+	_ = r.Set("past", "value", time.Minute)
+	if err := r.Set("past", "value", -time.Second); err != nil || r.IsExist("past") {
+		t.Errorf("Set with a past timeout should delete the value, got %v", err)
+	}
+	_ = r.Set("short", "value", time.Microsecond)
+	server.lock.Lock()
+	command := server.commands[len(server.commands)-1]
+	server.lock.Unlock()
+	if !strings.HasSuffix(command, "PX 1") {
+		t.Errorf("Set got command %s", command)
+	}
+	_ = r.Delete("token")
+	if val := r.Get("token"); val != nil || r.IsExist("token") {
+		t.Errorf("Get got a deleted value %v", val)
+	}
+	if val := r.Get("missing"); val != nil {
+		t.Errorf("Get got a missing value %v", val)
+	}
+}
+
+// TestRedis_ContextCache 测试 ContextCache 的原子操作
+func TestRedis_ContextCache(t *testing.T) {
+	server := newFakeServer(t, "")
+	r := NewRedis(Options{Addr: server.listener.Addr().String(), Prefix: "ks:"})
+	defer r.Close()
+	ctx := context.Background()
+	if _, err := r.GetWithContext(ctx, "missing"); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("GetWithContext should return ErrNotFound, got %v", err)
+	}
+	if set, err := r.SetNX(ctx, "lock", "a", time.Minute); !set || err != nil {
+		t.Errorf("SetNX got %v %v", set, err)
+	}
+	if set, _ := r.SetNX(ctx, "lock", "b", time.Minute); set || r.Get("lock") != "a" {
+		t.Errorf("SetNX should not overwrite an existing value")
+	}
+	if ttl, err := r.TTL(ctx, "lock"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL got %v %v", ttl, err)
+	}
+	if _, err := r.TTL(ctx, "missing"); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("TTL should return ErrNotFound, got %v", err)
+	}
+	_, _ = r.Incr(ctx, "counter", 2)
+	if val, err := r.Incr(ctx, "counter", 3); val != 5 || err != nil || r.Get("counter") != float64(5) {
+		t.Errorf("Incr got %v %v", val, err)
+	}
+	if ttl, _ := r.TTL(ctx, "counter"); ttl != cache.NoExpiration {
+		t.Errorf("TTL got %v want NoExpiration", ttl)
+	}
+	if _, err := r.Incr(ctx, "lock", 1); !errors.Is(err, cache.ErrNotInteger) {
+		t.Errorf("Incr should return ErrNotInteger, got %v", err)
+	}
+}
+
+// TestRedis_Auth 测试密码认证与选择数据库
+func TestRedis_Auth(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	r := NewRedis(Options{Addr: server.listener.Addr().String(), Password: "secret", DB: 2})
+	defer r.Close()
+	if err := r.Set("key", "value", time.Minute); err != nil {
+		t.Errorf("Set got a error %s", err.Error())
+	}
+	server.lock.Lock()
+	commands := append([]string(nil), server.commands...)
+	server.lock.Unlock()
+	if len(commands) < 2 || commands[0] != "AUTH secret" || commands[1] != "SELECT 2" {
+		t.Errorf("got commands %v", commands)
+	}
+	wrong := NewRedis(Options{Addr: server.listener.Addr().String(), Password: "wrong"})
+	defer wrong.Close()
+	if err := wrong.Set("key", "value", time.Minute); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Set should fail with a wrong password, got %v", err)
+	}
+}
+
+// TestRedis_Concurrent 测试并发使用连接池
+func TestRedis_Concurrent(t *testing.T) {
+	server := newFakeServer(t, "")
+	r := NewRedis(Options{Addr: server.listener.Addr().String(), PoolSize: 2})
+	defer r.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			for j := 0; j < 50; j++ {
+				_ = r.Set(key, j, time.Minute)
+				if val := r.Get(key); val != float64(j) {
+					t.Errorf("Get got a value %v want %d", val, j)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestRedis_ClosedConn 测试空闲连接被redis关闭后 换新连接重试
+func TestRedis_ClosedConn(t *testing.T) {
+	server := newFakeServer(t, "")
+	r := NewRedis(Options{Addr: server.listener.Addr().String(), PoolSize: 2})
+	defer r.Close()
+	if err := r.Set("key", "value", time.Minute); err != nil {
+		t.Errorf("Set got a error %s", err.Error())
+		return
+	}
+	server.closeConns()
+	// 等待连接关闭的消息到达客户端
+	time.Sleep(10 * time.Millisecond)
+	if val, err := r.GetWithContext(context.Background(), "key"); err != nil || val != "value" {
+		t.Errorf("GetWithContext got %v %v", val, err)
+	}
+}
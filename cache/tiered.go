@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultTieredLocalTTL 本地层默认的缓存时间
+const defaultTieredLocalTTL = 10 * time.Second
+
+// Tiered 两级缓存 读取时先读本地层 未命中时读共享层并回填到本地层
+// 回填的缓存时间不超过 LocalTTL 与共享层的剩余有效期
+// 写入与删除同时作用于两层 其他实例的本地层最多在 LocalTTL 后读到新的值
+// 一般本地层使用 Memory 共享层使用 redis 之类的网络缓存
+// SetNX Incr TTL 只作用于共享层 保证多个实例之间的原子性
+type Tiered struct {
+	Local    Cache         // 本地层 如 Memory
+	Shared   Cache         // 共享层 如 redis
+	LocalTTL time.Duration // 本地层的缓存时间 不会超过写入时的timeout 为0时使用10s
+}
+
+// NewTiered 实例化一个两级缓存 localTTL为0时使用10s
+func NewTiered(local, shared Cache, localTTL time.Duration) *Tiered {
+	return &Tiered{
+		Local:    local,
+		Shared:   shared,
+		LocalTTL: localTTL,
+	}
+}
+
+// Get 获取缓存的值 本地层未命中时读取共享层
+func (t *Tiered) Get(key string) interface{} {
+	if val := t.Local.Get(key); val != nil {
+		return val
+	}
+	val := t.Shared.Get(key)
+	if val != nil {
+		if ttl, ok := t.backfillTTL(context.Background(), key); ok {
+			_ = t.Local.Set(key, val, ttl)
+		}
+	}
+	return val
+}
+
+// Set 设置一个值 先写共享层 成功后再写本地层 timeout小于等于0时删除本地层的值
+func (t *Tiered) Set(key string, val interface{}, timeout time.Duration) error {
+	if err := t.Shared.Set(key, val, timeout); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return t.Local.Delete(key)
+	}
+	return t.Local.Set(key, val, t.localTTL(timeout))
+}
+
+// IsExist 判断值是否存在
+func (t *Tiered) IsExist(key string) bool {
+	return t.Local.IsExist(key) || t.Shared.IsExist(key)
+}
+
+// Delete 删除一个值 两层都会删除
+func (t *Tiered) Delete(key string) error {
+	localErr := t.Local.Delete(key)
+	if err := t.Shared.Delete(key); err != nil {
+		return err
+	}
+	return localErr
+}
+
+// localTTL 本地层的缓存时间 不超过timeout 和 LocalTTL timeout小于等于0时值已经过期 返回0
+func (t *Tiered) localTTL(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return 0
+	}
+	if ttl := t.maxLocalTTL(); timeout > ttl {
+		return ttl
+	}
+	return timeout
+}
+
+// maxLocalTTL 本地层最长的缓存时间
+func (t *Tiered) maxLocalTTL() time.Duration {
+	if t.LocalTTL <= 0 {
+		return defaultTieredLocalTTL
+	}
+	return t.LocalTTL
+}
+
+// backfillTTL 回填本地层的缓存时间 共享层支持 TTL 时不超过共享层的剩余有效期
+// 共享层的值在读取后已经过期时返回false 不再回填
+func (t *Tiered) backfillTTL(ctx context.Context, key string) (time.Duration, bool) {
+	ttl, err := NewContextCache(t.Shared).TTL(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return 0, false
+	}
+	if err != nil || ttl <= 0 {
+		// 共享层不支持 TTL 或者永不过期
+		return t.maxLocalTTL(), true
+	}
+	return t.localTTL(ttl), true
+}
+
+// GetWithContext 获取缓存的值 本地层未命中时读取共享层
+func (t *Tiered) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	local := NewContextCache(t.Local)
+	val, err := local.GetWithContext(ctx, key)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return val, err
+	}
+	if val, err = NewContextCache(t.Shared).GetWithContext(ctx, key); err != nil {
+		return nil, err
+	}
+	if ttl, ok := t.backfillTTL(ctx, key); ok {
+		_ = local.SetWithContext(ctx, key, val, ttl)
+	}
+	return val, nil
+}
+
+// SetWithContext 设置一个值 先写共享层 成功后再写本地层 timeout小于等于0时删除本地层的值
+func (t *Tiered) SetWithContext(ctx context.Context, key string, val interface{}, timeout time.Duration) error {
+	if err := NewContextCache(t.Shared).SetWithContext(ctx, key, val, timeout); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return NewContextCache(t.Local).DeleteWithContext(ctx, key)
+	}
+	return NewContextCache(t.Local).SetWithContext(ctx, key, val, t.localTTL(timeout))
+}
+
+// DeleteWithContext 删除一个值 两层都会删除
+func (t *Tiered) DeleteWithContext(ctx context.Context, key string) error {
+	localErr := NewContextCache(t.Local).DeleteWithContext(ctx, key)
+	if err := NewContextCache(t.Shared).DeleteWithContext(ctx, key); err != nil {
+		return err
+	}
+	return localErr
+}
+
+// SetNX 在共享层执行 成功后删除本地层的旧值
+func (t *Tiered) SetNX(ctx context.Context, key string, val interface{}, timeout time.Duration) (bool, error) {
+	set, err := NewContextCache(t.Shared).SetNX(ctx, key, val, timeout)
+	if set {
+		_ = t.Local.Delete(key)
+	}
+	return set, err
+}
+
+// Incr 在共享层执行 并删除本地层的旧值
+func (t *Tiered) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	val, err := NewContextCache(t.Shared).Incr(ctx, key, delta)
+	if err == nil {
+		_ = t.Local.Delete(key)
+	}
+	return val, err
+}
+
+// TTL 共享层的剩余有效期
+func (t *Tiered) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return NewContextCache(t.Shared).TTL(ctx, key)
+}
+
+// CompareAndDelete 共享层的值仍然等于old时删除 本地层的值无论是否相同都删除
+// 本地层可能还是旧值 只比较共享层 避免用本地的旧值误删其他实例刷新后的新值
+func (t *Tiered) CompareAndDelete(ctx context.Context, key string, old interface{}) (bool, error) {
+	deleted, err := CompareAndDelete(ctx, NewContextCache(t.Shared), key, old)
+	if err != nil {
+		return false, err
+	}
+	return deleted, NewContextCache(t.Local).DeleteWithContext(ctx, key)
+}
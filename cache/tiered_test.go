@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestTiered 测试本地层命中 回填 写穿透与删除
+func TestTiered(t *testing.T) {
+	shared := NewMemoryWithConfig(MemoryConfig{CleanupInterval: -1})
+	defer shared.Close()
+	local := NewMemoryWithConfig(MemoryConfig{CleanupInterval: -1})
+	defer local.Close()
+	tiered := NewTiered(local, shared, 20*time.Millisecond)
+	if err := tiered.Set("key", "value", time.Minute); err != nil {
+		t.Errorf("Set got a error %s", err.Error())
+		return
+	}
+	if shared.Get("key") != "value" || local.Get("key") != "value" {
+		t.Errorf("Set should write through, shared=%v local=%v", shared.Get("key"), local.Get("key"))
+	}
+	// 其他实例更新了共享层 本地层过期前仍然读到旧值
+	_ = shared.Set("key", "new", time.Minute)
+	if val := tiered.Get("key"); val != "value" {
+		t.Errorf("Get should hit the local layer, got %v", val)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if val := tiered.Get("key"); val != "new" || local.Get("key") != "new" {
+		t.Errorf("Get should fall back to the shared layer, got %v", val)
+	}
+	_ = tiered.Delete("key")
+	if tiered.Get("key") != nil || tiered.IsExist("key") || shared.IsExist("key") {
+		t.Errorf("Delete should remove both layers")
+	}
+	// 本地层的缓存时间不超过写入时的timeout
+	_ = tiered.Set("short", "value", 5*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if val := tiered.Get("short"); val != nil {
+		t.Errorf("Get got an expired value %v", val)
+	}
+	// 回填的缓存时间不超过共享层的剩余有效期
+	tiered.LocalTTL = time.Hour
+	for name, get := range map[string]func(key string) interface{}{
+		"Get": tiered.Get,
+		"GetWithContext": func(key string) interface{} {
+			val, _ := tiered.GetWithContext(context.Background(), key)
+			return val
+		},
+	} {
+		_ = shared.Set(name, "value", 10*time.Millisecond)
+		if val := get(name); val != "value" {
+			t.Errorf("%s got a value %v", name, val)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if val := get(name); val != nil || local.IsExist(name) {
+			t.Errorf("%s should not backfill beyond the shared TTL, got %v", name, val)
+		}
+	}
+
+	// 只比较共享层 本地层的旧值不会导致误删
+	_ = tiered.Set("cas", "old", time.Minute)
+	_ = shared.Set("cas", "new", time.Minute)
+	if deleted, err := tiered.CompareAndDelete(context.Background(), "cas", "old"); deleted || err != nil || shared.Get("cas") != "new" || local.IsExist("cas") {
+		t.Errorf("CompareAndDelete got %v %v", deleted, err)
+	}
+	if deleted, _ := tiered.CompareAndDelete(context.Background(), "cas", "new"); !deleted || tiered.IsExist("cas") {
+		t.Errorf("CompareAndDelete should delete a matching value")
+	}
+
+	// timeout小于等于0时两层都视为已经过期
+	_ = tiered.Set("expired", "value", time.Minute)
+	for _, get := range []func() interface{}{
+		func() interface{} { _ = tiered.Set("expired", "value", 0); return tiered.Get("expired") },
+		func() interface{} {
+			_ = tiered.SetWithContext(context.Background(), "expired", "value", -time.Second)
+			val, _ := tiered.GetWithContext(context.Background(), "expired")
+			return val
+		},
+	} {
+		if val := get(); val != nil || local.IsExist("expired") {
+			t.Errorf("Get got an expired value %v", val)
+		}
+	}
+}
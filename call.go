@@ -0,0 +1,68 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+)
+
+// CallOption 调整 Call 的请求方式
+type CallOption func(req *apiRequest)
+
+// WithFormBody 以form表单的方式提交参数
+func WithFormBody() CallOption {
+	return func(req *apiRequest) {
+		req.form = true
+	}
+}
+
+// WithJSONBody 以json的方式提交参数 默认方式
+func WithJSONBody() CallOption {
+	return func(req *apiRequest) {
+		req.form = false
+	}
+}
+
+// WithSign 是否使用 GenerateSign 对参数签名 默认签名
+func WithSign(sign bool) CallOption {
+	return func(req *apiRequest) {
+		req.sign = sign
+	}
+}
+
+// WithAccessToken 是否在query中携带 app_id 与 access_token 默认携带
+func WithAccessToken(withToken bool) CallOption {
+	return func(req *apiRequest) {
+		req.withToken = withToken
+	}
+}
+
+// WithIdempotent 声明请求是否幂等 幂等的请求才会按 RetryPolicy 重试 默认不幂等
+func WithIdempotent(idempotent bool) CallOption {
+	return func(req *apiRequest) {
+		req.idempotent = idempotent
+	}
+}
+
+// Call 请求sdk尚未封装的快手接口 复用token管理 签名与错误处理
+// endpoint 接口路径 会拼接 BaseApiHost 也可以传入完整地址 params 请求参数 可以是map或者结构体 out 返回值解析的目标 可以为nil
+// 默认以json提交 签名并携带 app_id 与 access_token 可以通过 CallOption 调整
+// result 不为成功时返回 *KuaiShouError
+func (k *KuaiShou) Call(ctx context.Context, endpoint string, params interface{}, out interface{}, opts ...CallOption) error {
+	paramsMap := map[string]interface{}{}
+	if values, ok := params.(map[string]interface{}); ok {
+		// 复制一份 签名时会写入 app_id 与 sign 不修改调用方的map
+		for key, val := range values {
+			paramsMap[key] = val
+		}
+	} else if params != nil {
+		var err error
+		if paramsMap, err = util.JsonStructToMap(params); err != nil {
+			return err
+		}
+	}
+	req := apiRequest{api: endpoint, params: paramsMap, sign: true, withToken: true}
+	for _, opt := range opts {
+		opt(&req)
+	}
+	return k.do(ctx, req, out)
+}
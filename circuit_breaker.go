@@ -0,0 +1,242 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 接口分组 每个分组使用独立的熔断器
+const (
+	GroupAuth  = "auth"  // 登陆与获取token /oauth2/
+	GroupEpay  = "epay"  // 担保支付 /openapi/mp/developer/epay/
+	GroupOther = "other" // 其他接口
+)
+
+// ErrCircuitOpen 熔断器处于打开状态 请求没有发出 可以通过 errors.Is 判断
+var ErrCircuitOpen = errors.New("kuaishou: circuit breaker is open")
+
+// CircuitOpenError 熔断器打开时返回的错误
+type CircuitOpenError struct {
+	Group string // 被熔断的接口分组
+}
+
+// Error 实现error接口
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("kuaishou: circuit breaker is open : group=%s", e.Group)
+}
+
+// Is 支持 errors.Is(err, ErrCircuitOpen)
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitState 熔断器的状态
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // 关闭 请求正常发出
+	CircuitOpen                         // 打开 请求直接失败
+	CircuitHalfOpen                     // 半开 只允许少量探测请求
+)
+
+// String 状态名称
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig 熔断器配置 每个接口分组使用一个独立的熔断器
+type CircuitBreakerConfig struct {
+	FailureThreshold int                                       // 连续失败多少次后打开 小于1时按5处理
+	OpenTimeout      time.Duration                             // 打开后经过多久进入半开状态 为0时按30s处理
+	HalfOpenProbes   int                                       // 半开状态允许的探测请求数 全部成功后关闭 小于1时按1处理
+	IsFailure        func(err error) bool                      // 判断错误是否计入失败 为空时只统计网络错误与5xx 即 util.IsRetryable
+	OnStateChange    func(group string, from, to CircuitState) // 状态变化时回调 可用于告警
+}
+
+// EndpointGroup 获取接口所属的分组
+func EndpointGroup(endpoint string) string {
+	switch {
+	case strings.HasPrefix(endpoint, "/oauth2/"):
+		return GroupAuth
+	case strings.HasPrefix(endpoint, "/openapi/mp/developer/epay/"):
+		return GroupEpay
+	}
+	return GroupOther
+}
+
+// circuitBreaker 一个分组的熔断器
+type circuitBreaker struct {
+	lock     sync.Mutex
+	group    string
+	config   CircuitBreakerConfig
+	state    CircuitState
+	failures int       // 关闭状态下连续失败的次数
+	openedAt time.Time // 进入打开状态的时间
+	probes   int       // 半开状态下已经发出的探测请求数
+	success  int       // 半开状态下探测成功的次数
+}
+
+// newCircuitBreaker 实例化熔断器并补全默认配置
+func newCircuitBreaker(group string, config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenProbes < 1 {
+		config.HalfOpenProbes = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = util.IsRetryable
+	}
+	return &circuitBreaker{group: group, config: config}
+}
+
+// State 当前状态
+func (b *circuitBreaker) State() CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// stateChange 一次状态变化
+type stateChange struct {
+	from, to CircuitState
+}
+
+// allow 判断请求是否可以发出
+func (b *circuitBreaker) allow() error {
+	var changes []stateChange
+	// 先释放锁再回调 避免回调中读取状态时死锁
+	defer func() { b.notify(changes) }()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.config.OpenTimeout {
+		changes = append(changes, b.setState(CircuitHalfOpen))
+	}
+	switch b.state {
+	case CircuitOpen:
+		return &CircuitOpenError{Group: b.group}
+	case CircuitHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes {
+			return &CircuitOpenError{Group: b.group}
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// record 记录请求的结果
+func (b *circuitBreaker) record(err error) {
+	failed := err != nil && b.config.IsFailure(err)
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CircuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			changes = append(changes, b.setState(CircuitOpen))
+		}
+	case CircuitHalfOpen:
+		if failed {
+			changes = append(changes, b.setState(CircuitOpen))
+			return
+		}
+		b.success++
+		if b.success >= b.config.HalfOpenProbes {
+			changes = append(changes, b.setState(CircuitClosed))
+		}
+	}
+}
+
+// release 请求没有得到结果 如被限流或者ctx已经结束 不计入成功或者失败 半开状态下归还探测名额
+func (b *circuitBreaker) release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == CircuitHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// setState 切换状态并重置计数 调用方需要持有锁
+func (b *circuitBreaker) setState(state CircuitState) stateChange {
+	change := stateChange{from: b.state, to: state}
+	b.state = state
+	b.failures, b.probes, b.success = 0, 0, 0
+	if state == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+	return change
+}
+
+// notify 回调状态变化 调用方不能持有锁
+func (b *circuitBreaker) notify(changes []stateChange) {
+	if b.config.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		if change.from != change.to {
+			b.config.OnStateChange(b.group, change.from, change.to)
+		}
+	}
+}
+
+// newCircuitBreakers 为每个接口分组实例化熔断器 config为nil时不熔断
+func newCircuitBreakers(config *CircuitBreakerConfig) map[string]*circuitBreaker {
+	if config == nil {
+		return nil
+	}
+	breakers := map[string]*circuitBreaker{}
+	for _, group := range []string{GroupAuth, GroupEpay, GroupOther} {
+		breakers[group] = newCircuitBreaker(group, *config)
+	}
+	return breakers
+}
+
+// circuitBreakerMiddleware 熔断器打开时请求直接返回 ErrCircuitOpen
+func circuitBreakerMiddleware(breakers map[string]*circuitBreaker) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			breaker := breakers[EndpointGroup(req.Endpoint)]
+			if err := breaker.allow(); err != nil {
+				return nil, err
+			}
+			res, err := next(ctx, req)
+			// 被限流或者调用方取消时无法说明接口是否恢复
+			if errors.Is(err, ErrRateLimited) || ctx.Err() != nil {
+				breaker.release()
+				return res, err
+			}
+			breaker.record(err)
+			return res, err
+		}
+	}
+}
+
+// CircuitState 获取某个接口分组熔断器的当前状态 未配置熔断器时总是关闭
+func (k *KuaiShou) CircuitState(group string) CircuitState {
+	if breaker, ok := k.circuitBreakers[group]; ok {
+		return breaker.State()
+	}
+	return CircuitClosed
+}
@@ -0,0 +1,186 @@
+// ks-token-server 集中管理快手小程序的access_token
+// 只有token服务持有 AppSecret 其他服务通过 access_token.RemoteAccessToken 获取token
+//
+// 用法:
+//
+//	ks-token-server -config ks-token-server.json
+//
+// 配置文件示例:
+//
+//	{
+//	  "addr": ":8080",
+//	  "api_keys": ["internal-key"],
+//	  "apps": [{"app_id": "ks123", "app_secret": ""}],
+//	  "redis": {"addr": "127.0.0.1:6379", "prefix": "ks:"}
+//	}
+//
+// app_secret 为空时 设置了 app_secret_file 则从文件读取 文件修改后自动使用新的密钥
+// 否则读取环境变量 KS_APP_SECRET_<app_id>
+// 环境变量 KS_TOKEN_SERVER_API_KEYS 中逗号分隔的密钥会追加到 api_keys
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	kuaishou "github.com/HeartGarlic/kuaishou-server-api-sdk"
+	accessToken "github.com/HeartGarlic/kuaishou-server-api-sdk/access-token"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache/redis"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Config token服务的配置
+type Config struct {
+	Addr      string         `json:"addr"`       // 监听地址 默认 :8080
+	APIKeys   []string       `json:"api_keys"`   // 允许访问的密钥
+	Apps      []App          `json:"apps"`       // 管理的小程序
+	Redis     *redis.Options `json:"redis"`      // 使用redis缓存token 多个token服务实例共享 为空时使用本地缓存
+	CacheFile string         `json:"cache_file"` // 没有配置redis时 使用文件缓存token 重启后不需要重新获取 为空时使用内存缓存
+}
+
+// App 一个小程序
+type App struct {
+	AppId         string `json:"app_id"`
+	AppSecret     string `json:"app_secret"`
+	AppSecretFile string `json:"app_secret_file"` // 保存密钥的文件 如挂载的 kubernetes secret
+}
+
+func main() {
+	configPath := flag.String("config", "ks-token-server.json", "配置文件路径")
+	addr := flag.String("addr", "", "监听地址 覆盖配置文件中的 addr")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("ks-token-server: load config: %s", err)
+	}
+	if *addr != "" {
+		config.Addr = *addr
+	}
+	if err = run(config); err != nil {
+		log.Fatalf("ks-token-server: %s", err)
+	}
+}
+
+// loadConfig 读取配置文件并补全环境变量中的配置
+func loadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	if config.Addr == "" {
+		config.Addr = ":8080"
+	}
+	for _, key := range strings.Split(os.Getenv("KS_TOKEN_SERVER_API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			config.APIKeys = append(config.APIKeys, key)
+		}
+	}
+	if len(config.APIKeys) == 0 {
+		return nil, errors.New("api_keys is required")
+	}
+	if len(config.Apps) == 0 {
+		return nil, errors.New("apps is required")
+	}
+	for i, app := range config.Apps {
+		if app.AppSecret == "" && app.AppSecretFile == "" {
+			config.Apps[i].AppSecret = os.Getenv("KS_APP_SECRET_" + app.AppId)
+		}
+		if config.Apps[i].AppSecret == "" && app.AppSecretFile == "" {
+			return nil, fmt.Errorf("app_secret is required : app_id=%s", app.AppId)
+		}
+	}
+	return config, nil
+}
+
+// run 启动http服务 收到退出信号后停止
+func run(config *Config) error {
+	metrics := kuaishou.NewMemoryMetrics()
+	shared := &kuaishou.KuaiShouAppletConfig{
+		RetryPolicy:    kuaishou.NewRetryPolicy(),
+		Logger:         kuaishou.NewStdLogger(nil),
+		Metrics:        metrics,
+		TokenRefresher: &accessToken.RefresherConfig{Jitter: 0.2},
+	}
+	switch {
+	case config.Redis != nil:
+		shared.Cache = redis.NewRedis(*config.Redis)
+		// 多个token服务实例共享redis时 只有一个实例刷新token
+		shared.TokenLocker = cache.NewLocker(shared.Cache)
+	case config.CacheFile != "":
+		file, err := cache.NewFile(config.CacheFile)
+		if err != nil {
+			return err
+		}
+		shared.Cache = file
+	}
+	registry := kuaishou.NewRegistry(shared)
+	for _, app := range config.Apps {
+		appConfig := &kuaishou.KuaiShouAppletConfig{AppId: app.AppId, AppSecret: app.AppSecret}
+		if app.AppSecret == "" {
+			appConfig.SecretProvider = util.NewFileSecret(app.AppSecretFile)
+		}
+		if _, err := registry.Add(appConfig); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		for _, appId := range registry.AppIds() {
+			registry.Remove(appId)
+		}
+	}()
+
+	handler := &accessToken.Handler{
+		Lookup: func(appId string) (accessToken.AccessToken, bool) {
+			client, err := registry.Get(appId)
+			if err != nil {
+				return nil, false
+			}
+			return client.AccessToken, true
+		},
+		APIKeys: config.APIKeys,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(accessToken.EndpointRemoteToken, handler)
+	mux.Handle(accessToken.EndpointRemoteInvalidate, handler)
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("ks-token-server: listening on %s apps=%v", config.Addr, registry.AppIds())
+		errs <- server.ListenAndServe()
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case <-signals:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
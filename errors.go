@@ -0,0 +1,26 @@
+package kuaishou_server_api_sdk
+
+import (
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"net/url"
+)
+
+// KuaiShouError 快手接口返回的错误 包含 result 错误码 接口名称 http状态码与原始返回值
+// 可以通过 errors.As 获取 通过 errors.Is 判断错误分类
+type KuaiShouError = util.KuaiShouError
+
+// 错误分类 用法: errors.Is(err, ErrInvalidToken)
+var (
+	ErrInvalidToken        = util.ErrInvalidToken
+	ErrSignatureFailed     = util.ErrSignatureFailed
+	ErrDuplicateOutOrderNo = util.ErrDuplicateOutOrderNo
+	ErrInsufficientBalance = util.ErrInsufficientBalance
+)
+
+// endpointName 获取接口名称 即去掉host与query参数的路径
+func endpointName(api string) string {
+	if parse, err := url.Parse(api); err == nil {
+		return parse.Path
+	}
+	return api
+}
@@ -1,28 +1,31 @@
 package kuaishou_server_api_sdk
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	accessToken "github.com/HeartGarlic/kuaishou-server-api-sdk/access-token"
 	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
 	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
-	"net/url"
+	"io"
 	"sort"
 	"strings"
 )
 
-// 声明常量
+// 声明常量 接口地址均为相对 BaseApiHost 的路径
+// 可以作为 KuaiShouAppletConfig.EndpointHosts 的key 为单个接口指定host
 const (
-	successCode               = 1
-	code2Session              = "https://open.kuaishou.com/oauth2/mp/code2session"
-	payCreateOrder            = "https://open.kuaishou.com/openapi/mp/developer/epay/create_order"              // 有收银台版本
-	payCreateOrderWithChannel = "https://open.kuaishou.com/openapi/mp/developer/epay/create_order_with_channel" // 无收银台版本
-	queryOrder                = "https://open.kuaishou.com/openapi/mp/developer/epay/query_order"               // 查询支付状态
-	applyRefund               = "https://open.kuaishou.com/openapi/mp/developer/epay/apply_refund"              //  支付退款
-	queryRefund               = "https://open.kuaishou.com/openapi/mp/developer/epay/query_refund"              // 退款查询接口
-	settle                    = "https://open.kuaishou.com/openapi/mp/developer/epay/settle"                    // 结算
-	querySettle               = "https://open.kuaishou.com/openapi/mp/developer/epay/query_settle"              // 结算查询
+	EndpointCode2Session              = "/oauth2/mp/code2session"
+	EndpointAccessToken               = accessToken.EndpointAccessToken
+	EndpointPayCreateOrder            = "/openapi/mp/developer/epay/create_order"              // 有收银台版本
+	EndpointPayCreateOrderWithChannel = "/openapi/mp/developer/epay/create_order_with_channel" // 无收银台版本
+	EndpointQueryOrder                = "/openapi/mp/developer/epay/query_order"               // 查询支付状态
+	EndpointApplyRefund               = "/openapi/mp/developer/epay/apply_refund"              //  支付退款
+	EndpointQueryRefund               = "/openapi/mp/developer/epay/query_refund"              // 退款查询接口
+	EndpointSettle                    = "/openapi/mp/developer/epay/settle"                    // 结算
+	EndpointQuerySettle               = "/openapi/mp/developer/epay/query_settle"              // 结算查询
 )
 
 // KuaiShou 基础的客户端类
@@ -30,36 +33,161 @@ const (
 // 包含登陆 获取access token
 // 担保支付
 type KuaiShou struct {
-	BaseApiHost string      // api基础地址 https://open.kuaishou.com/
-	AppId       string      // 快手小程序的appid
-	AppSecret   string      // 快手小程序的app secret
-	Cache       cache.Cache // 基础的缓存接口
-	AccessToken accessToken.AccessToken
+	BaseApiHost    string            // api基础地址 https://open.kuaishou.com/
+	EndpointHosts  map[string]string // 单独指定某些接口的host key为接口路径 如 EndpointQueryOrder
+	AppId          string            // 快手小程序的appid
+	AppSecret      string            // 快手小程序的app secret 设置了 SecretProvider 时不再使用
+	Cache          cache.Cache       // 基础的缓存接口
+	AccessToken    accessToken.AccessToken
+	SecretProvider SecretProvider // 签名 获取token 验证回调时获取 AppSecret 为nil时使用 AppSecret
+	HTTPClient     util.Doer      // 所有接口请求使用的http客户端
+	RetryPolicy    *RetryPolicy   // 失败重试策略 为nil时不重试
+	Middlewares    []Middleware   // 请求中间件 按顺序包装每一次http请求
+	Logger         Logger         // 请求日志 为nil时不记录
+	Metrics        Metrics        // 监控 为nil时不统计
+	Tracer         Tracer         // 链路追踪 为nil时不追踪
+
+	rateLimiters    rateLimiters               // 每个接口的令牌桶 通过 SetRateLimit 修改
+	circuitBreakers map[string]*circuitBreaker // 每个接口分组的熔断器
 }
 
 // KuaiShouAppletConfig 快手小程序需要的参数
 type KuaiShouAppletConfig struct {
-	AppId       string      // 快手小程序的appid
-	AppSecret   string      // 快手小程序的app secret
-	Cache       cache.Cache // 基础的缓存接口
-	AccessToken accessToken.AccessToken
+	BaseApiHost   string            // api基础地址 为空时使用 util.DefaultBaseApiHost 可以指向测试环境或者出口代理
+	EndpointHosts map[string]string // 单独指定某些接口的host key为接口路径 如 EndpointQueryOrder
+	AppId         string            // 快手小程序的appid
+	AppSecret     string            // 快手小程序的app secret
+	Cache         cache.Cache       // 基础的缓存接口
+	AccessToken   accessToken.AccessToken
+	HTTPClient    util.Doer            // http客户端 可设置超时 代理 TLS等 为空时使用 util.DefaultHTTPClient
+	RetryPolicy   *RetryPolicy         // 失败重试策略 为nil时不重试 可以使用 NewRetryPolicy 获取默认策略
+	Middlewares   []Middleware         // 请求中间件 作用于所有接口请求以及默认token管理获取token的请求
+	Logger        Logger               // 请求日志 敏感字段会自动脱敏 可以使用 NewStdLogger
+	Metrics       Metrics              // 监控 统计接口请求与token刷新 可以使用 NewMemoryMetrics
+	Tracer        Tracer               // 链路追踪 根据传入的ctx为每次请求与token刷新开启span
+	RateLimits    map[string]RateLimit // 每个接口的限流配置 key为接口路径 如 EndpointQueryOrder 运行时使用 KuaiShou.SetRateLimit 修改
+	// CircuitBreaker 熔断器配置 auth epay 等接口分组各自独立熔断 为nil时不熔断
+	CircuitBreaker *CircuitBreakerConfig
+	// TokenRefresher 默认token管理的后台刷新配置 为nil时只在缓存失效时刷新 启用后需要调用 Close 停止
+	TokenRefresher *accessToken.RefresherConfig
+	// SecretProvider 每次签名 获取token 验证回调时获取密钥 支持不重启轮换 为nil时使用 AppSecret
+	// 使用 util.NewRotatingSecret 包装后 轮换后的宽限期内回调使用新旧密钥签名都可以通过验证
+	SecretProvider SecretProvider
+	// TokenLocker 默认token管理刷新token时使用的锁 多个实例共享缓存时使用 cache.NewLocker 避免同时刷新 为nil时只在进程内加锁
+	TokenLocker cache.Locker
+	// ContextCache 只实现了 cache.ContextCache 的缓存 Cache 为空时使用 cache.FromContextCache 转换后作为 Cache
+	ContextCache cache.ContextCache
 }
 
 // NewKuaiShou 实例化一个快手客户端
 func NewKuaiShou(config *KuaiShouAppletConfig) *KuaiShou {
 	// 如果存在cache组件就实例化一个内存缓存
 	if config.Cache == nil {
-		config.Cache = cache.NewMemory()
+		config.Cache = config.resolveCache()
+	}
+	// 如果未设置api地址 就使用默认的
+	if config.BaseApiHost == "" {
+		config.BaseApiHost = util.DefaultBaseApiHost
+	}
+	// 如果未设置http客户端 就使用默认的
+	if config.HTTPClient == nil {
+		config.HTTPClient = util.DefaultHTTPClient
 	}
 	// 如果未设置token管理 就使用默认的
 	if config.AccessToken == nil {
 		config.AccessToken = accessToken.NewDefaultAccessToken(config.AppId, config.AppSecret, config.Cache)
 	}
-	return &KuaiShou{
-		AppId:       config.AppId,
-		AppSecret:   config.AppSecret,
-		Cache:       config.Cache,
-		AccessToken: config.AccessToken,
+	k := &KuaiShou{
+		BaseApiHost:     config.BaseApiHost,
+		EndpointHosts:   config.EndpointHosts,
+		AppId:           config.AppId,
+		AppSecret:       config.AppSecret,
+		Cache:           config.Cache,
+		AccessToken:     config.AccessToken,
+		SecretProvider:  config.SecretProvider,
+		HTTPClient:      config.HTTPClient,
+		RetryPolicy:     config.RetryPolicy,
+		Middlewares:     config.Middlewares,
+		Logger:          config.Logger,
+		Metrics:         config.Metrics,
+		Tracer:          config.Tracer,
+		circuitBreakers: newCircuitBreakers(config.CircuitBreaker),
+	}
+	for endpoint, limit := range config.RateLimits {
+		k.SetRateLimit(endpoint, limit)
+	}
+	k.bindAccessToken()
+	if token, ok := k.AccessToken.(*accessToken.DefaultAccessToken); ok && !token.RefresherStarted() {
+		if token.Locker == nil {
+			token.Locker = config.TokenLocker
+		}
+		// 共用的token管理已经启动过后台刷新时忽略 其他错误设置了 Logger 时记录
+		if config.TokenRefresher != nil {
+			if err := token.StartRefresher(*config.TokenRefresher); err != nil && !errors.Is(err, accessToken.ErrRefresherStarted) && k.Logger != nil {
+				k.Logger.Log(context.Background(), LogEvent{Endpoint: EndpointAccessToken, Error: RedactText(err.Error())})
+			}
+		}
+	}
+	return k
+}
+
+// resolveCache 设置了 ContextCache 时转换为 Cache 否则使用内存缓存
+func (config *KuaiShouAppletConfig) resolveCache() cache.Cache {
+	if config.ContextCache != nil {
+		return cache.FromContextCache(config.ContextCache)
+	}
+	return cache.NewMemory()
+}
+
+// secretProvider 设置了 SecretProvider 时使用 否则使用固定的 AppSecret
+func (k *KuaiShou) secretProvider() SecretProvider {
+	if k.SecretProvider != nil {
+		return k.SecretProvider
+	}
+	return util.StaticSecret(k.AppSecret)
+}
+
+// Close 停止token管理的后台刷新 token管理没有实现 io.Closer 时什么都不做
+func (k *KuaiShou) Close() error {
+	if closer, ok := k.AccessToken.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// bindAccessToken 默认的token管理与客户端共用同一套请求配置
+// 已经单独设置过的字段不会覆盖 已经启动后台刷新的token管理正在被其他客户端使用 不再修改
+func (k *KuaiShou) bindAccessToken() {
+	token, ok := k.AccessToken.(*accessToken.DefaultAccessToken)
+	if !ok || token.RefresherStarted() {
+		return
+	}
+	if token.HTTPClient == nil {
+		token.HTTPClient = k.HTTPClient
+	}
+	if token.SecretProvider == nil {
+		// 每次获取时读取客户端当前的密钥 之后修改 AppSecret 或者 SecretProvider 同样生效
+		token.SecretProvider = util.SecretFunc(func(ctx context.Context) (string, error) {
+			return k.secretProvider().Secret(ctx)
+		})
+	}
+	if token.BaseApiHost == "" {
+		token.BaseApiHost = k.BaseApiHost
+		if host, ok := k.EndpointHosts[EndpointAccessToken]; ok {
+			token.BaseApiHost = host
+		}
+	}
+	if token.RetryPolicy == nil {
+		token.RetryPolicy = k.RetryPolicy
+	}
+	if token.Middlewares == nil {
+		token.Middlewares = k.middlewares()
+	}
+	if token.Observer == nil && k.Metrics != nil {
+		token.Observer = k.Metrics
+	}
+	if token.Tracer == nil {
+		token.Tracer = k.Tracer
 	}
 }
 
@@ -72,18 +200,18 @@ type Code2SessionResponse struct {
 }
 
 // Code2Session 实现具体的业务方法 登陆
-func (k *KuaiShou) Code2Session(code string) (code2SessionResponse Code2SessionResponse, err error) {
-	post, err := util.PostForm(code2Session, url.Values{"js_code": []string{code}, "app_id": []string{k.AppId}, "app_secret": []string{k.AppSecret}})
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(post, &code2SessionResponse)
+func (k *KuaiShou) Code2Session(code string) (Code2SessionResponse, error) {
+	return k.Code2SessionWithContext(context.Background(), code)
+}
+
+// Code2SessionWithContext 登陆 ctx取消或超时会中断请求
+func (k *KuaiShou) Code2SessionWithContext(ctx context.Context, code string) (code2SessionResponse Code2SessionResponse, err error) {
+	secret, err := k.secretProvider().Secret(ctx)
 	if err != nil {
 		return
 	}
-	if code2SessionResponse.Result != successCode {
-		return code2SessionResponse, fmt.Errorf(code2SessionResponse.ErrorMsg)
-	}
+	params := map[string]interface{}{"js_code": code, "app_id": k.AppId, "app_secret": secret}
+	err = k.do(ctx, apiRequest{api: EndpointCode2Session, params: params, form: true}, &code2SessionResponse)
 	return
 }
 
@@ -129,16 +257,21 @@ type OrderInfo struct {
 }
 
 // PayCreateOrder 预下单
-func (k *KuaiShou) PayCreateOrder(payCreateOrderParams PayCreateOrderParams) (payCreateOrderResponse PayCreateOrderResponse, err error) {
-	token, _ := k.AccessToken.GetAccessToken()
-	host := payCreateOrder
+func (k *KuaiShou) PayCreateOrder(payCreateOrderParams PayCreateOrderParams) (PayCreateOrderResponse, error) {
+	return k.PayCreateOrderWithContext(context.Background(), payCreateOrderParams)
+}
+
+// PayCreateOrderWithContext 预下单 ctx取消或超时会中断请求
+func (k *KuaiShou) PayCreateOrderWithContext(ctx context.Context, payCreateOrderParams PayCreateOrderParams) (payCreateOrderResponse PayCreateOrderResponse, err error) {
+	api := EndpointPayCreateOrder
 	if len(payCreateOrderParams.Provider.Provider) > 0 {
-		host = payCreateOrderWithChannel
+		api = EndpointPayCreateOrderWithChannel
 	}
-	// 拼接请求地址
-	api := fmt.Sprintf("%s?app_id=%s&access_token=%s", host, k.AppId, token)
 	// 拼接请求参数 还需要加签
 	paramsMap, err := util.JsonStructToMap(payCreateOrderParams)
+	if err != nil {
+		return
+	}
 	paramsMap["multi_copies_goods_info"] = ""
 	paramsMap["provider"] = ""
 	// 重新修改生成map的方法 有些字段需要是 json string multi_copies_goods_info
@@ -150,31 +283,31 @@ func (k *KuaiShou) PayCreateOrder(payCreateOrderParams PayCreateOrderParams) (pa
 		provider, _ := json.Marshal(payCreateOrderParams.Provider)
 		paramsMap["provider"] = provider
 	}
-	paramsMap["sign"] = k.GenerateSign(paramsMap)
 	// 开始请求接口
-	postJSON, err := util.PostJSON(api, paramsMap)
-	if err != nil {
-		return
-	}
-	// 解析返回值
-	err = json.Unmarshal(postJSON, &payCreateOrderResponse)
-	if err != nil {
-		return
-	}
-	if payCreateOrderResponse.Result != successCode {
-		return payCreateOrderResponse, fmt.Errorf(payCreateOrderResponse.ErrorMsg)
-	}
+	// 同一个 out_order_no 且不覆盖已有订单时 重复下单会返回已创建的订单 可以安全重试
+	idempotent := payCreateOrderParams.OutOrderNo != "" && payCreateOrderParams.CancelOrder != 1
+	err = k.do(ctx, apiRequest{api: api, params: paramsMap, sign: true, withToken: true, idempotent: idempotent}, &payCreateOrderResponse)
 	return
 }
 
-// CallbackCheckSignature 验证回调签名
+// CallbackCheckSignature 验证回调签名 失败时返回的错误可以通过 errors.Is(err, ErrSignatureFailed) 判断
 func (k *KuaiShou) CallbackCheckSignature(oldSign, body string) error {
-	newSignStr := fmt.Sprintf("%s%s", body, k.AppSecret)
-	newSign := fmt.Sprintf("%x", md5.Sum([]byte(newSignStr)))
-	if newSign != oldSign {
-		return fmt.Errorf("验证签名失败 newSign: %s oldSign: %s", newSign, oldSign)
+	secrets, err := k.secretProvider().Secrets(context.Background())
+	if err != nil {
+		return err
+	}
+	// 密钥轮换的宽限期内 使用新旧密钥签名都可以通过
+	var newSign string
+	for i, secret := range secrets {
+		sign := fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%s%s", body, secret))))
+		if sign == oldSign {
+			return nil
+		}
+		if i == 0 {
+			newSign = sign
+		}
 	}
-	return nil
+	return fmt.Errorf("验证签名失败 newSign: %s oldSign: %s : %w", newSign, oldSign, ErrSignatureFailed)
 }
 
 // PayCallbackResponse 支付回调的参数解析
@@ -244,29 +377,36 @@ type ExtraInfo struct {
 
 // QueryOrder 查询订单状态
 // outOrderNo 商户系统内部订单号，只能是数字、大小写字母_-*且在同一个商户号下唯一 1217752501201407033233368018
-func (k *KuaiShou) QueryOrder(outOrderNo string) (queryOrderResponse QueryOrderResponse, err error) {
-	token, _ := k.AccessToken.GetAccessToken()
+func (k *KuaiShou) QueryOrder(outOrderNo string) (QueryOrderResponse, error) {
+	return k.QueryOrderWithContext(context.Background(), outOrderNo)
+}
+
+// QueryOrderWithContext 查询订单状态 ctx取消或超时会中断请求
+func (k *KuaiShou) QueryOrderWithContext(ctx context.Context, outOrderNo string) (queryOrderResponse QueryOrderResponse, err error) {
 	params := map[string]interface{}{
 		"out_order_no": outOrderNo,
 	}
-	params["sign"] = k.GenerateSign(params)
-	api := fmt.Sprintf("%s?app_id=%s&access_token=%s", queryOrder, k.AppId, token)
-	postJSON, err := util.PostJSON(api, params)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(postJSON, &queryOrderResponse)
-	if err != nil {
-		return
-	}
-	if queryOrderResponse.Result != successCode {
-		return queryOrderResponse, fmt.Errorf(queryOrderResponse.ErrorMsg)
-	}
+	err = k.do(ctx, apiRequest{api: EndpointQueryOrder, params: params, sign: true, withToken: true, idempotent: true}, &queryOrderResponse)
 	return
 }
 
-// GenerateSign 生成请求签名
+// GenerateSign 生成请求签名 读取密钥失败时返回空字符串 设置了 Logger 时记录错误
+//
+// Deprecated: 无法返回读取密钥的错误 请使用 GenerateSignWithContext
 func (k *KuaiShou) GenerateSign(params map[string]interface{}) string {
+	sign, err := k.GenerateSignWithContext(context.Background(), params)
+	if err != nil && k.Logger != nil {
+		k.Logger.Log(context.Background(), LogEvent{Endpoint: "GenerateSign", Error: RedactText(err.Error())})
+	}
+	return sign
+}
+
+// GenerateSignWithContext 使用 SecretProvider 当前的密钥生成签名
+func (k *KuaiShou) GenerateSignWithContext(ctx context.Context, params map[string]interface{}) (string, error) {
+	secret, err := k.secretProvider().Secret(ctx)
+	if err != nil {
+		return "", err
+	}
 	params["app_id"] = k.AppId
 	var paramsKey []string
 	for k, v := range params {
@@ -289,7 +429,7 @@ func (k *KuaiShou) GenerateSign(params map[string]interface{}) string {
 	for _, v := range paramsKey {
 		paramsVal = append(paramsVal, fmt.Sprintf("%s=%+v", v, params[v]))
 	}
-	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(paramsVal, "&")+k.AppSecret)))
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(paramsVal, "&")+secret))), nil
 }
 
 // ApplyRefundParams 支付退款接口参数
@@ -312,23 +452,23 @@ type ApplyRefundResponse struct {
 }
 
 // ApplyRefund 支付退款接口
-func (k *KuaiShou) ApplyRefund(applyRefundParams ApplyRefundParams) (applyRefundResponse ApplyRefundResponse, err error) {
-	token, _ := k.AccessToken.GetAccessToken()
-	params, _ := util.JsonStructToMap(applyRefundParams)
-	params["multi_copies_goods_info"] = ""
-	if applyRefundParams.MultiCopiesGoodsInfo.Copies > 0 {
-		params["multi_copies_goods_info"], _ = json.Marshal(applyRefundParams.MultiCopiesGoodsInfo)
-	}
-	params["sign"] = k.GenerateSign(params)
-	api := fmt.Sprintf("%s?app_id=%s&access_token=%s", applyRefund, k.AppId, token)
-	postJSON, err := util.PostJSON(api, params)
+func (k *KuaiShou) ApplyRefund(applyRefundParams ApplyRefundParams) (ApplyRefundResponse, error) {
+	return k.ApplyRefundWithContext(context.Background(), applyRefundParams)
+}
+
+// ApplyRefundWithContext 支付退款接口 ctx取消或超时会中断请求
+func (k *KuaiShou) ApplyRefundWithContext(ctx context.Context, applyRefundParams ApplyRefundParams) (applyRefundResponse ApplyRefundResponse, err error) {
+	params, err := util.JsonStructToMap(applyRefundParams)
 	if err != nil {
 		return
 	}
-	err = json.Unmarshal(postJSON, &applyRefundResponse)
-	if err != nil {
-		return
+	params["multi_copies_goods_info"] = ""
+	if applyRefundParams.MultiCopiesGoodsInfo.Copies > 0 {
+		params["multi_copies_goods_info"], _ = json.Marshal(applyRefundParams.MultiCopiesGoodsInfo)
 	}
+	// 同一个 out_refund_no 重复请求不会重复退款 可以安全重试
+	idempotent := applyRefundParams.OutRefundNo != ""
+	err = k.do(ctx, apiRequest{api: EndpointApplyRefund, params: params, sign: true, withToken: true, idempotent: idempotent}, &applyRefundResponse)
 	return
 }
 
@@ -349,21 +489,16 @@ type RefundInfo struct {
 }
 
 // QueryRefund 退款查询接口
-func (k *KuaiShou) QueryRefund(outRefundNo string) (queryRefundResponse QueryRefundResponse, err error) {
-	token, _ := k.AccessToken.GetAccessToken()
+func (k *KuaiShou) QueryRefund(outRefundNo string) (QueryRefundResponse, error) {
+	return k.QueryRefundWithContext(context.Background(), outRefundNo)
+}
+
+// QueryRefundWithContext 退款查询接口 ctx取消或超时会中断请求
+func (k *KuaiShou) QueryRefundWithContext(ctx context.Context, outRefundNo string) (queryRefundResponse QueryRefundResponse, err error) {
 	params := map[string]interface{}{
 		"out_refund_no": outRefundNo,
 	}
-	params["sign"] = k.GenerateSign(params)
-	api := fmt.Sprintf("%s?app_id=%s&access_token=%s", queryRefund, k.AppId, token)
-	postJSON, err := util.PostJSON(api, params)
-	if err != nil {
-		return QueryRefundResponse{}, err
-	}
-	err = json.Unmarshal(postJSON, &queryRefundResponse)
-	if err != nil {
-		return
-	}
+	err = k.do(ctx, apiRequest{api: EndpointQueryRefund, params: params, sign: true, withToken: true, idempotent: true}, &queryRefundResponse)
 	return
 }
 
@@ -423,23 +558,24 @@ type SettleResponse struct {
 }
 
 // Settle 请求结算接口
-func (k *KuaiShou) Settle(settleParams SettleParams) (settleResponse SettleResponse, err error) {
-	token, _ := k.AccessToken.GetAccessToken()
-	params, _ := util.JsonStructToMap(settleParams)
+func (k *KuaiShou) Settle(settleParams SettleParams) (SettleResponse, error) {
+	return k.SettleWithContext(context.Background(), settleParams)
+}
+
+// SettleWithContext 请求结算接口 ctx取消或超时会中断请求
+func (k *KuaiShou) SettleWithContext(ctx context.Context, settleParams SettleParams) (settleResponse SettleResponse, err error) {
+	params, err := util.JsonStructToMap(settleParams)
+	if err != nil {
+		return
+	}
 	params["multi_copies_goods_info"] = ""
 	if settleParams.MultiCopiesGoodsInfo.Copies > 0 {
 		params["multi_copies_goods_info"], _ = json.Marshal(settleParams.MultiCopiesGoodsInfo)
 	}
 	// 开始请求api
-	api := fmt.Sprintf("%s?app_id=%s&access_token=%s", settle, k.AppId, token)
-	postJSON, err := util.PostJSON(api, params)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(postJSON, &settleResponse)
-	if err != nil {
-		return
-	}
+	// 同一个 out_settle_no 重复请求不会重复结算 可以安全重试
+	idempotent := settleParams.OutSettleNo != ""
+	err = k.do(ctx, apiRequest{api: EndpointSettle, params: params, sign: true, withToken: true, idempotent: idempotent}, &settleResponse)
 	return
 }
 
@@ -460,21 +596,17 @@ type SettleInfo struct {
 }
 
 // QuerySettle 结算结果查询
-func (k *KuaiShou) QuerySettle(outSettleNo string) (querySettleResponse QuerySettleResponse, err error) {
-	token, _ := k.AccessToken.GetAccessToken()
+func (k *KuaiShou) QuerySettle(outSettleNo string) (QuerySettleResponse, error) {
+	return k.QuerySettleWithContext(context.Background(), outSettleNo)
+}
+
+// QuerySettleWithContext 结算结果查询 ctx取消或超时会中断请求
+func (k *KuaiShou) QuerySettleWithContext(ctx context.Context, outSettleNo string) (querySettleResponse QuerySettleResponse, err error) {
 	params := map[string]interface{}{
 		"out_settle_no": outSettleNo,
 	}
 	// 开始请求api
-	api := fmt.Sprintf("%s?app_id=%s&access_token=%s", querySettle, k.AppId, token)
-	postJSON, err := util.PostJSON(api, params)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(postJSON, &querySettleResponse)
-	if err != nil {
-		return
-	}
+	err = k.do(ctx, apiRequest{api: EndpointQuerySettle, params: params, sign: true, withToken: true, idempotent: true}, &querySettleResponse)
 	return
 }
 
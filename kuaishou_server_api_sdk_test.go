@@ -1,7 +1,23 @@
 package kuaishou_server_api_sdk
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
+	accessToken "github.com/HeartGarlic/kuaishou-server-api-sdk/access-token"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -124,3 +140,983 @@ func TestKuaiShou_ApplyRefund(t *testing.T) {
 	}
 	t.Logf("ApplyRefund got a value %+v", refund)
 }
+
+// doerFunc 模拟网络请求的http客户端
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// jsonResponse 构造一个模拟的json返回值
+func jsonResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// mockToken 模拟获取token接口返回的token
+const mockToken = `{"result":1,"access_token":"mock_token","expires_in":172800}`
+
+// newTestKuaiShou 创建测试用的快手实例 获取token的请求固定返回 mock_token 其余请求交给 handle 处理
+// config 为nil时使用测试的 AppId 与 AppSecret
+func newTestKuaiShou(config *KuaiShouAppletConfig, handle func(req *http.Request) (*http.Response, error)) *KuaiShou {
+	if config == nil {
+		config = &KuaiShouAppletConfig{}
+	}
+	if config.AppId == "" {
+		config.AppId = "ks_test_app"
+	}
+	if config.AppSecret == "" && config.SecretProvider == nil {
+		config.AppSecret = "ks_test_secret"
+	}
+	config.HTTPClient = doerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == EndpointAccessToken {
+			return jsonResponse(http.StatusOK, mockToken), nil
+		}
+		return handle(req)
+	})
+	return NewKuaiShou(config)
+}
+
+// TestKuaiShou_HTTPClient 测试token与接口请求都使用注入的http客户端
+func TestKuaiShou_HTTPClient(t *testing.T) {
+	var paths []string
+	client := newTestKuaiShou(nil, func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.Path)
+		if req.URL.Query().Get("access_token") != "mock_token" {
+			t.Errorf("HTTPClient got access_token %s", req.URL.Query().Get("access_token"))
+		}
+		return jsonResponse(http.StatusOK, `{"result":1,"payment_info":{"out_order_no":"123456","pay_status":"SUCCESS"}}`), nil
+	})
+	order, err := client.QueryOrder("123456")
+	if err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	if order.PaymentInfo.PayStatus != "SUCCESS" || len(paths) != 1 {
+		t.Errorf("QueryOrder got a value %+v paths %v", order, paths)
+	}
+}
+
+// TestKuaiShou_KuaiShouError 测试接口错误可以通过 errors.Is errors.As 判断
+func TestKuaiShou_KuaiShouError(t *testing.T) {
+	statusCode, body := http.StatusOK, `{"result":100200107,"error_msg":"订单号重复"}`
+	// 文档没有给出订单号重复的错误码 测试中注册一个
+	defer util.RegisterResultCode(100200107, ErrDuplicateOutOrderNo)()
+	client := newTestKuaiShou(nil, func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(statusCode, body), nil
+	})
+	_, err := client.PayCreateOrder(PayCreateOrderParams{OutOrderNo: "123456"})
+	var kuaiShouError *KuaiShouError
+	if !errors.Is(err, ErrDuplicateOutOrderNo) || !errors.As(err, &kuaiShouError) {
+		t.Errorf("PayCreateOrder got a error %v", err)
+		return
+	}
+	if kuaiShouError.Endpoint != "/openapi/mp/developer/epay/create_order" || kuaiShouError.Result != 100200107 || string(kuaiShouError.Body) != body {
+		t.Errorf("PayCreateOrder got a error %+v", kuaiShouError)
+	}
+	// http状态码错误
+	statusCode, body = http.StatusBadGateway, "bad gateway"
+	_, err = client.QueryOrder("123456")
+	if !errors.As(err, &kuaiShouError) || kuaiShouError.StatusCode != http.StatusBadGateway || string(kuaiShouError.Body) != body {
+		t.Errorf("QueryOrder got a error %v", err)
+	}
+}
+
+// TestKuaiShou_ResultCodes 测试 result 错误码与错误分类的对应关系
+func TestKuaiShou_ResultCodes(t *testing.T) {
+	restore := util.RegisterResultCode(100200199, ErrInsufficientBalance)
+	sentinels := []error{ErrInvalidToken, ErrSignatureFailed, ErrDuplicateOutOrderNo, ErrInsufficientBalance}
+	for _, c := range []struct {
+		result int
+		class  error // 为nil时不属于任何分类
+	}{
+		{result: util.ResultInvalidToken, class: ErrInvalidToken},
+		{result: util.ResultExpiredToken, class: ErrInvalidToken},
+		{result: 100200100}, // 参数有误 不属于任何分类
+		{result: 100200199, class: ErrInsufficientBalance},
+	} {
+		err := util.NewResultError(EndpointQueryOrder, c.result, "", nil)
+		for _, sentinel := range sentinels {
+			if errors.Is(err, sentinel) != (sentinel == c.class) {
+				t.Errorf("result %d errors.Is(%v) got %v", c.result, sentinel, !(sentinel == c.class))
+			}
+		}
+	}
+	// 恢复后不再属于任何分类 已有的归类也会恢复
+	restore()
+	if err := util.NewResultError(EndpointQueryOrder, 100200199, "", nil); errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("result 100200199 should not be classified after restore")
+	}
+	util.RegisterResultCode(util.ResultExpiredToken, ErrSignatureFailed)()
+	if err := util.NewResultError(EndpointQueryOrder, util.ResultExpiredToken, "", nil); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("result %d should be restored to ErrInvalidToken", util.ResultExpiredToken)
+	}
+}
+
+// TestKuaiShou_ResultCheck 测试所有接口统一校验result 并返回token获取失败的错误
+func TestKuaiShou_ResultCheck(t *testing.T) {
+	tokenBody := `{"result":100200100,"error_msg":"app_secret错误"}`
+	var requested int
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(req.URL.Path, "/oauth2/access_token") {
+				return jsonResponse(http.StatusOK, tokenBody), nil
+			}
+			requested++
+			return jsonResponse(http.StatusOK, `{"result":100200100,"error_msg":"参数有误"}`), nil
+		}),
+	})
+	// token获取失败 不应该继续请求接口
+	var kuaiShouError *KuaiShouError
+	if _, err := client.Settle(SettleParams{OutOrderNo: "123456", OutSettleNo: "123456"}); !errors.As(err, &kuaiShouError) || kuaiShouError.Endpoint != "/oauth2/access_token" || requested != 0 {
+		t.Errorf("Settle got a error %v requested %d", err, requested)
+		return
+	}
+	tokenBody = `{"result":1,"access_token":"mock_token","expires_in":172800}`
+	calls := map[string]func() error{
+		"ApplyRefund": func() error { _, err := client.ApplyRefund(ApplyRefundParams{OutOrderNo: "123456"}); return err },
+		"QueryRefund": func() error { _, err := client.QueryRefund("123456"); return err },
+		"Settle":      func() error { _, err := client.Settle(SettleParams{OutOrderNo: "123456"}); return err },
+		"QuerySettle": func() error { _, err := client.QuerySettle("123456"); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.As(err, &kuaiShouError) || kuaiShouError.Result != 100200100 {
+			t.Errorf("%s got a error %v", name, err)
+		}
+	}
+}
+
+// TestKuaiShou_InvalidTokenRetry 测试token失效时删除缓存 重新获取token并重放请求
+func TestKuaiShou_InvalidTokenRetry(t *testing.T) {
+	var tokens []string
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(req.URL.Path, "/oauth2/access_token") {
+				return jsonResponse(http.StatusOK, fmt.Sprintf(`{"result":1,"access_token":"mock_token_%d","expires_in":172800}`, len(tokens))), nil
+			}
+			tokens = append(tokens, req.URL.Query().Get("access_token"))
+			if len(tokens) == 1 {
+				return jsonResponse(http.StatusOK, `{"result":100200101,"error_msg":"access_token无效"}`), nil
+			}
+			return jsonResponse(http.StatusOK, `{"result":1,"refund_info":{"refund_status":"SUCCESS"}}`), nil
+		}),
+	})
+	refund, err := client.QueryRefund("123456")
+	if err != nil {
+		t.Errorf("QueryRefund got a error %s", err.Error())
+		return
+	}
+	if refund.RefundInfo.RefundStatus != "SUCCESS" || len(tokens) != 2 || tokens[0] != "mock_token_0" || tokens[1] != "mock_token_1" {
+		t.Errorf("QueryRefund got a value %+v tokens %v", refund, tokens)
+	}
+}
+
+// TestKuaiShou_InvalidTokenReplaced 测试重放前缓存中的token已经被其他实例替换时 不删除新token 直接使用新token重放
+func TestKuaiShou_InvalidTokenReplaced(t *testing.T) {
+	memory := cache.NewMemory()
+	var tokens []string
+	var client *KuaiShou
+	client = newTestKuaiShou(&KuaiShouAppletConfig{Cache: memory}, func(req *http.Request) (*http.Response, error) {
+		tokens = append(tokens, req.URL.Query().Get("access_token"))
+		if len(tokens) == 1 {
+			// 模拟其他实例在这次请求期间已经刷新了token
+			_ = memory.Set(client.AccessToken.GetCacheKey(), "fresh_token", time.Hour)
+			return jsonResponse(http.StatusOK, `{"result":100200101,"error_msg":"access_token无效"}`), nil
+		}
+		return jsonResponse(http.StatusOK, `{"result":1,"refund_info":{"refund_status":"SUCCESS"}}`), nil
+	})
+	if _, err := client.QueryRefund("123456"); err != nil {
+		t.Errorf("QueryRefund got a error %s", err.Error())
+		return
+	}
+	if fmt.Sprint(tokens) != "[mock_token fresh_token]" || memory.Get(client.AccessToken.GetCacheKey()) != "fresh_token" {
+		t.Errorf("QueryRefund got tokens %v cache %v", tokens, memory.Get(client.AccessToken.GetCacheKey()))
+	}
+}
+
+// TestKuaiShou_TokenContext 测试ctx取消时中断获取token的请求 以及等待刷新锁的调用方超时后直接返回
+func TestKuaiShou_TokenContext(t *testing.T) {
+	release := make(chan struct{})
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-release:
+				return jsonResponse(http.StatusOK, mockToken), nil
+			}
+		}),
+	})
+	// 请求接口期间ctx被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := client.AccessToken.GetAccessTokenWithContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetAccessTokenWithContext got a error %v", err)
+		return
+	}
+	// 另一个goroutine持有刷新锁时 等待的调用方在ctx超时后放弃
+	holder := make(chan error, 1)
+	go func() {
+		_, err := client.AccessToken.GetAccessToken()
+		holder <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.AccessToken.GetAccessTokenWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("GetAccessTokenWithContext got a error %v after %s", err, time.Since(start))
+	}
+	close(release)
+	if err := <-holder; err != nil {
+		t.Errorf("GetAccessToken got a error %s", err.Error())
+	}
+}
+
+// TestKuaiShou_Call 测试调用sdk未封装的接口
+func TestKuaiShou_Call(t *testing.T) {
+	client := newTestKuaiShou(nil, func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get("access_token") != "" {
+			t.Errorf("Call got access_token %s", req.URL.Query().Get("access_token"))
+		}
+		if err := req.ParseForm(); err != nil || req.PostForm.Get("open_id") != "open_id" || req.PostForm.Get("sign") != "" {
+			t.Errorf("Call got form %v", req.PostForm)
+		}
+		return jsonResponse(http.StatusOK, `{"result":1,"data":{"status":"OK"}}`), nil
+	})
+	var out struct {
+		Data struct {
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+	params := map[string]interface{}{"open_id": "open_id"}
+	err := client.Call(context.Background(), "https://open.kuaishou.com/openapi/mp/developer/new_api", params, &out, WithFormBody(), WithSign(false), WithAccessToken(false))
+	if err != nil {
+		t.Errorf("Call got a error %s", err.Error())
+		return
+	}
+	if out.Data.Status != "OK" || len(params) != 1 {
+		t.Errorf("Call got a value %+v params %v", out, params)
+	}
+}
+
+// TestKuaiShou_BaseApiHost 测试接口地址使用 BaseApiHost 拼接 并支持单个接口指定host
+func TestKuaiShou_BaseApiHost(t *testing.T) {
+	var baseRequests, settleRequests []string
+	base := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		baseRequests = append(baseRequests, r.URL.Path)
+		if r.URL.Path == EndpointAccessToken {
+			_, _ = w.Write([]byte(`{"result":1,"access_token":"mock_token","expires_in":172800}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":1}`))
+	}))
+	defer base.Close()
+	settleHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settleRequests = append(settleRequests, r.URL.Path)
+		_, _ = w.Write([]byte(`{"result":1,"settle_no":"987654"}`))
+	}))
+	defer settleHost.Close()
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		BaseApiHost:   base.URL,
+		EndpointHosts: map[string]string{EndpointSettle: settleHost.URL},
+		AppId:         "ks_test_app",
+		AppSecret:     "ks_test_secret",
+	})
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	settle, err := client.Settle(SettleParams{OutOrderNo: "123456", OutSettleNo: "123456"})
+	if err != nil || settle.SettleNo != "987654" {
+		t.Errorf("Settle got a error %v value %+v", err, settle)
+		return
+	}
+	if fmt.Sprint(baseRequests) != fmt.Sprint([]string{EndpointAccessToken, EndpointQueryOrder}) || fmt.Sprint(settleRequests) != fmt.Sprint([]string{EndpointSettle}) {
+		t.Errorf("BaseApiHost got requests %v %v", baseRequests, settleRequests)
+	}
+}
+
+// TestKuaiShou_RetryPolicy 测试幂等的请求遇到5xx时重试 非幂等的请求不重试
+func TestKuaiShou_RetryPolicy(t *testing.T) {
+	var attempts []RetryAttempt
+	requests := map[string]int{}
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			OnAttempt: func(attempt RetryAttempt) {
+				attempts = append(attempts, attempt)
+			},
+		},
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			requests[req.URL.Path]++
+			if req.URL.Path == EndpointAccessToken {
+				if requests[req.URL.Path] == 1 {
+					return jsonResponse(http.StatusServiceUnavailable, ""), nil
+				}
+				return jsonResponse(http.StatusOK, mockToken), nil
+			}
+			if requests[req.URL.Path] < 3 {
+				return jsonResponse(http.StatusBadGateway, ""), nil
+			}
+			return jsonResponse(http.StatusOK, `{"result":1}`), nil
+		}),
+	})
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	if requests[EndpointAccessToken] != 2 || requests[EndpointQueryOrder] != 3 || len(attempts) != 5 {
+		t.Errorf("QueryOrder got requests %v attempts %d", requests, len(attempts))
+		return
+	}
+	// 覆盖已有订单的下单请求不是幂等的 不重试
+	if _, err := client.PayCreateOrder(PayCreateOrderParams{OutOrderNo: "123456", CancelOrder: 1}); err == nil || requests[EndpointPayCreateOrder] != 1 {
+		t.Errorf("PayCreateOrder got a error %v requests %v", err, requests)
+	}
+}
+
+// TestKuaiShou_Middlewares 测试中间件可以看到接口名称 签名后的参数与解析后的返回值
+func TestKuaiShou_Middlewares(t *testing.T) {
+	var endpoints []string
+	var order *QueryOrderResponse
+	client := newTestKuaiShou(&KuaiShouAppletConfig{
+		Middlewares: []Middleware{func(next RoundTripFunc) RoundTripFunc {
+			return func(ctx context.Context, req *Request) (*Response, error) {
+				endpoints = append(endpoints, req.Endpoint)
+				if req.Endpoint == EndpointQueryOrder && req.Params["sign"] == nil {
+					t.Errorf("Middlewares got params %v", req.Params)
+				}
+				req.Header.Set("X-Request-Id", "request_id")
+				res, err := next(ctx, req)
+				if err == nil && req.Endpoint == EndpointQueryOrder {
+					order = res.Value.(*QueryOrderResponse)
+				}
+				return res, err
+			}
+		}},
+	}, func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("X-Request-Id") != "request_id" {
+			t.Errorf("Middlewares got header %v", req.Header)
+		}
+		return jsonResponse(http.StatusOK, `{"result":1,"payment_info":{"pay_status":"SUCCESS"}}`), nil
+	})
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	if fmt.Sprint(endpoints) != fmt.Sprint([]string{EndpointAccessToken, EndpointQueryOrder}) || order == nil || order.PaymentInfo.PayStatus != "SUCCESS" {
+		t.Errorf("Middlewares got endpoints %v order %+v", endpoints, order)
+	}
+}
+
+// memoryLogger 记录日志事件用于测试
+type memoryLogger struct {
+	events []LogEvent
+}
+
+func (l *memoryLogger) Log(ctx context.Context, event LogEvent) {
+	l.events = append(l.events, event)
+}
+
+// TestKuaiShou_Logger 测试日志中的 app_secret access_token sign session_key 都被脱敏
+func TestKuaiShou_Logger(t *testing.T) {
+	logger := &memoryLogger{}
+	buffer := &bytes.Buffer{}
+	client := newTestKuaiShou(&KuaiShouAppletConfig{
+		Logger:      logger,
+		Middlewares: []Middleware{loggingMiddleware(NewStdLogger(log.New(buffer, "", 0)))},
+	}, func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == EndpointCode2Session {
+			return jsonResponse(http.StatusOK, `{"result":1,"session_key":"mock_session_key","open_id":"open_id"}`), nil
+		}
+		return nil, fmt.Errorf("Post %q: connection reset", req.URL.String())
+	})
+	if _, err := client.Code2Session("js_code"); err != nil {
+		t.Errorf("Code2Session got a error %s", err.Error())
+		return
+	}
+	if _, err := client.QueryOrder("123456"); err == nil {
+		t.Errorf("QueryOrder should got a error")
+		return
+	}
+	if len(logger.events) != 3 || logger.events[2].OutOrderNo != "123456" || logger.events[0].Response["open_id"] != "open_id" {
+		t.Errorf("Logger got events %+v", logger.events)
+		return
+	}
+	logs := fmt.Sprintf("%+v %s", logger.events, buffer.String())
+	for _, secret := range []string{"ks_test_secret", "mock_token", "mock_session_key", client.GenerateSign(map[string]interface{}{"out_order_no": "123456"})} {
+		if strings.Contains(logs, secret) {
+			t.Errorf("Logger got secret %s in %s", secret, logs)
+		}
+	}
+}
+
+// TestKuaiShou_Metrics 测试统计接口请求次数 token刷新次数与缓存命中率
+func TestKuaiShou_Metrics(t *testing.T) {
+	metrics := NewMemoryMetrics()
+	client := newTestKuaiShou(&KuaiShouAppletConfig{Metrics: metrics}, func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, `{"result":100200100,"error_msg":"参数有误"}`), nil
+	})
+	for i := 0; i < 2; i++ {
+		_, _ = client.QueryOrder("123456")
+	}
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	text := recorder.Body.String()
+	for _, line := range []string{
+		`kuaishou_requests_total{endpoint="/openapi/mp/developer/epay/query_order",status="200",result="100200100"} 2`,
+		`kuaishou_request_duration_seconds_count{endpoint="/openapi/mp/developer/epay/query_order"} 2`,
+		`kuaishou_token_refresh_total{status="success"} 1`,
+		`kuaishou_token_cache_total{status="hit"} 1`,
+		`kuaishou_token_cache_total{status="miss"} 1`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Metrics missing %s in\n%s", line, text)
+		}
+	}
+	if metrics.TokenCacheHitRatio() != 0.5 || !strings.Contains(metrics.String(), `"token_cache_hit":1`) {
+		t.Errorf("Metrics got %s", metrics.String())
+	}
+}
+
+// testSpan 记录属性的span
+type testSpan struct {
+	name       string
+	parent     *testSpan
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attributes[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.attributes["error"] = err }
+func (s *testSpan) End()                                       { s.ended = true }
+
+// testTracer 记录所有span的tracer
+type testTracer struct {
+	spans []*testSpan
+}
+
+type testSpanKey struct{}
+
+func (tr *testTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	span := &testSpan{name: name, parent: parent, attributes: map[string]interface{}{}}
+	tr.spans = append(tr.spans, span)
+	return context.WithValue(ctx, testSpanKey{}, span), span
+}
+
+// TestKuaiShou_Tracer 测试每次请求与token刷新都会开启span 并沿用ctx中的父级span
+func TestKuaiShou_Tracer(t *testing.T) {
+	tracer := &testTracer{}
+	client := newTestKuaiShou(&KuaiShouAppletConfig{Tracer: tracer}, func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, `{"result":1,"refund_no":"654321"}`), nil
+	})
+	ctx, root := tracer.StartSpan(context.Background(), "handler")
+	if _, err := client.ApplyRefundWithContext(ctx, ApplyRefundParams{OutOrderNo: "123456", OutRefundNo: "654321"}); err != nil {
+		t.Errorf("ApplyRefund got a error %s", err.Error())
+		return
+	}
+	if len(tracer.spans) != 5 {
+		t.Errorf("Tracer got %d spans", len(tracer.spans))
+		return
+	}
+	call, refresh, token, refund := tracer.spans[1], tracer.spans[2], tracer.spans[3], tracer.spans[4]
+	if call.parent != root || refresh.name != "kuaishou token refresh" || refresh.parent != call || token.parent != refresh || refund.parent != call {
+		t.Errorf("Tracer got spans %+v %+v %+v %+v", call, refresh, token, refund)
+	}
+	if call.name != "kuaishou "+EndpointApplyRefund || call.attributes["kuaishou.attempts"] != 1 || !call.ended {
+		t.Errorf("Tracer got call span %+v", call)
+	}
+	if refund.attributes["kuaishou.out_refund_no"] != "654321" || refund.attributes["kuaishou.result"] != 1 || refund.attributes["kuaishou.attempt"] != 1 || !refund.ended {
+		t.Errorf("Tracer got span attributes %+v", refund.attributes)
+	}
+}
+
+// TestKuaiShou_RateLimits 测试限流 令牌不足时立即失败或者阻塞等待
+func TestKuaiShou_RateLimits(t *testing.T) {
+	logger, metrics := &memoryLogger{}, NewMemoryMetrics()
+	client := newTestKuaiShou(&KuaiShouAppletConfig{
+		Logger:  logger,
+		Metrics: metrics,
+		RateLimits: map[string]RateLimit{
+			EndpointQueryOrder:  {Rate: 0.001, Burst: 1},
+			EndpointQuerySettle: {Rate: 50, Burst: 1, Wait: true},
+		},
+	}, func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, `{"result":1}`), nil
+	})
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	if _, err := client.QueryOrder("123456"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("QueryOrder got a error %v", err)
+		return
+	}
+	// 被限流拒绝的请求在日志与监控中单独记录
+	if event := logger.events[len(logger.events)-1]; event.StatusCode != StatusRateLimited {
+		t.Errorf("Logger got event %+v", event)
+	}
+	if line := `kuaishou_requests_total{endpoint="/openapi/mp/developer/epay/query_order",status="rate_limited",result="0"} 1`; !strings.Contains(metrics.Text(), line) {
+		t.Errorf("Metrics missing %s in\n%s", line, metrics.Text())
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.QuerySettle("123456"); err != nil {
+			t.Errorf("QuerySettle got a error %s", err.Error())
+			return
+		}
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Errorf("QuerySettle should wait for tokens, took %s", time.Since(start))
+	}
+	// 等待时间超过ctx的期限时直接失败
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := client.QuerySettleWithContext(ctx, "123456"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("QuerySettle got a error %v", err)
+		return
+	}
+	// 运行时修改限流配置 重新创建令牌桶
+	client.SetRateLimit(EndpointQueryOrder, RateLimit{Rate: 0.001, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := client.QueryOrder("123456"); err != nil {
+			t.Errorf("QueryOrder got a error %s", err.Error())
+			return
+		}
+	}
+	client.SetRateLimit(EndpointQueryOrder, RateLimit{})
+	client.SetRateLimit(EndpointApplyRefund, RateLimit{Rate: 0.001, Burst: 1})
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder should not be limited after removing the limit, got %s", err.Error())
+	}
+	_, _ = client.ApplyRefund(ApplyRefundParams{OutOrderNo: "123456"})
+	if _, err := client.ApplyRefund(ApplyRefundParams{OutOrderNo: "123456"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("ApplyRefund got a error %v", err)
+	}
+}
+
+// TestKuaiShou_CircuitBreaker 测试连续失败后熔断 超时后半开探测 探测成功后关闭
+func TestKuaiShou_CircuitBreaker(t *testing.T) {
+	var changes []string
+	var requests int
+	statusCode := http.StatusServiceUnavailable
+	client := newTestKuaiShou(&KuaiShouAppletConfig{
+		CircuitBreaker: &CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenTimeout:      20 * time.Millisecond,
+			OnStateChange: func(group string, from, to CircuitState) {
+				changes = append(changes, fmt.Sprintf("%s:%s->%s", group, from, to))
+			},
+		},
+	}, func(req *http.Request) (*http.Response, error) {
+		requests++
+		return jsonResponse(statusCode, `{"result":1}`), nil
+	})
+	for i := 0; i < 3; i++ {
+		_, _ = client.QueryOrder("123456")
+	}
+	if _, err := client.QueryOrder("123456"); !errors.Is(err, ErrCircuitOpen) || requests != 2 || client.CircuitState(GroupEpay) != CircuitOpen {
+		t.Errorf("QueryOrder got a error %v requests %d", err, requests)
+		return
+	}
+	// auth 分组不受影响
+	if client.CircuitState(GroupAuth) != CircuitClosed {
+		t.Errorf("CircuitState got auth %s", client.CircuitState(GroupAuth))
+	}
+	time.Sleep(30 * time.Millisecond)
+	statusCode = http.StatusOK
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	if fmt.Sprint(changes) != "[epay:closed->open epay:open->half-open epay:half-open->closed]" {
+		t.Errorf("CircuitBreaker got changes %v", changes)
+	}
+}
+
+// TestKuaiShou_CircuitBreakerNeutral 测试半开状态下被限流或者取消的请求不计入探测结果
+func TestKuaiShou_CircuitBreakerNeutral(t *testing.T) {
+	statusCode := http.StatusServiceUnavailable
+	client := newTestKuaiShou(&KuaiShouAppletConfig{
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond},
+		RateLimits: map[string]RateLimit{
+			EndpointQueryOrder: {Rate: 0.001, Burst: 1},
+		},
+	}, func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(statusCode, `{"result":1}`), nil
+	})
+	_, _ = client.QueryOrder("123456")
+	time.Sleep(30 * time.Millisecond)
+	// 令牌已经用完 半开状态的探测被限流
+	if _, err := client.QueryOrder("123456"); !errors.Is(err, ErrRateLimited) || client.CircuitState(GroupEpay) != CircuitHalfOpen {
+		t.Errorf("QueryOrder got a error %v state %s", err, client.CircuitState(GroupEpay))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.QuerySettleWithContext(ctx, "123456"); err == nil || client.CircuitState(GroupEpay) != CircuitHalfOpen {
+		t.Errorf("QuerySettle got a error %v state %s", err, client.CircuitState(GroupEpay))
+		return
+	}
+	// 探测名额已经归还 后续请求仍然可以探测
+	statusCode = http.StatusOK
+	if _, err := client.QuerySettle("123456"); err != nil || client.CircuitState(GroupEpay) != CircuitClosed {
+		t.Errorf("QuerySettle got a error %v state %s", err, client.CircuitState(GroupEpay))
+	}
+}
+
+// contextOnlyCache 只实现了 cache.ContextCache 的缓存
+type contextOnlyCache struct {
+	cache.ContextCache
+}
+
+// TestKuaiShou_ContextCache 测试只实现了 cache.ContextCache 的缓存可以直接配置
+func TestKuaiShou_ContextCache(t *testing.T) {
+	memory := cache.NewMemory()
+	client := newTestKuaiShou(&KuaiShouAppletConfig{ContextCache: &contextOnlyCache{ContextCache: cache.NewContextCache(memory)}}, func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, `{"result":1}`), nil
+	})
+	if _, err := client.QueryOrder("123456"); err != nil {
+		t.Errorf("QueryOrder got a error %s", err.Error())
+		return
+	}
+	if !memory.IsExist(client.AccessToken.GetCacheKey()) {
+		t.Errorf("token should be stored in the ContextCache")
+	}
+	registry := NewRegistry(&KuaiShouAppletConfig{ContextCache: &contextOnlyCache{ContextCache: cache.NewContextCache(memory)}})
+	if _, err := registry.Add(&KuaiShouAppletConfig{AppId: "ks_app_a", AppSecret: "secret"}); err != nil {
+		t.Errorf("Add got a error %s", err.Error())
+		return
+	}
+	_ = registry.shared.Cache.Set("ks_app_a:key", "value", time.Minute)
+	if !memory.IsExist("ks_app_a:key") {
+		t.Errorf("Registry should share the ContextCache")
+	}
+}
+
+// TestKuaiShou_AppSecret 测试没有设置 SecretProvider 时 签名 获取token 验证回调使用客户端当前的 AppSecret
+func TestKuaiShou_AppSecret(t *testing.T) {
+	var secrets []string
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "secret_v1",
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == EndpointAccessToken {
+				_ = req.ParseForm()
+				secrets = append(secrets, req.PostForm.Get("app_secret"))
+				return jsonResponse(http.StatusOK, mockToken), nil
+			}
+			return jsonResponse(http.StatusOK, `{"result":1}`), nil
+		}),
+	})
+	_, _ = client.QueryOrder("123456")
+	oldSign := client.GenerateSign(map[string]interface{}{"out_order_no": "123456"})
+	client.AppSecret = "secret_v2"
+	_ = client.AccessToken.Invalidate()
+	_, _ = client.QueryOrder("123456")
+	if fmt.Sprint(secrets) != "[secret_v1 secret_v2]" {
+		t.Errorf("token fetch got secrets %v", secrets)
+	}
+	if newSign := client.GenerateSign(map[string]interface{}{"out_order_no": "123456"}); newSign == oldSign {
+		t.Errorf("GenerateSign should use the new AppSecret")
+	}
+	body := `{"data":{"status":"SUCCESS"},"biz_type":"PAYMENT","app_id":"ks_test_app"}`
+	if err := client.CallbackCheckSignature(fmt.Sprintf("%x", md5.Sum([]byte(body+"secret_v2"))), body); err != nil {
+		t.Errorf("CallbackCheckSignature got a error %s", err.Error())
+	}
+}
+
+// TestRegistry 测试多个小程序共用缓存与http客户端 并根据回调的 app_id 找到客户端
+func TestRegistry(t *testing.T) {
+	shared := cache.NewMemory()
+	registry := NewRegistry(&KuaiShouAppletConfig{
+		Cache: shared,
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == EndpointAccessToken {
+				_ = req.ParseForm()
+				return jsonResponse(http.StatusOK, fmt.Sprintf(`{"result":1,"access_token":"token_%s","expires_in":172800}`, req.PostForm.Get("app_id"))), nil
+			}
+			return jsonResponse(http.StatusOK, `{"result":1}`), nil
+		}),
+	})
+	for _, appId := range []string{"ks_app_a", "ks_app_b"} {
+		if _, err := registry.Add(&KuaiShouAppletConfig{AppId: appId, AppSecret: appId + "_secret"}); err != nil {
+			t.Errorf("Add got a error %s", err.Error())
+			return
+		}
+	}
+	client, err := registry.ResolveCallback(`{"data":{"status":"SUCCESS"},"biz_type":"PAYMENT","app_id":"ks_app_b"}`)
+	if err != nil || client.AppId != "ks_app_b" {
+		t.Errorf("ResolveCallback got a error %v", err)
+		return
+	}
+	if token, err := client.AccessToken.GetAccessToken(); err != nil || token != "token_ks_app_b" {
+		t.Errorf("GetAccessToken got %s %v", token, err)
+		return
+	}
+	if !shared.IsExist("ks_app_b:kuaishou_server_api_sdk_access_token_ks_app_b") {
+		t.Errorf("Registry should store token in the shared cache with app_id prefix")
+	}
+	registry.Remove("ks_app_a")
+	if _, err = registry.Get("ks_app_a"); !errors.Is(err, ErrAppNotFound) || fmt.Sprint(registry.AppIds()) != "[ks_app_b]" {
+		t.Errorf("Get got a error %v app_ids %v", err, registry.AppIds())
+	}
+}
+
+// failingCache 读取总是失败的缓存 模拟redis故障
+type failingCache struct {
+	*cache.Memory
+}
+
+func (c *failingCache) GetWithContext(ctx context.Context, key string) (interface{}, error) {
+	return nil, errors.New("connection refused")
+}
+
+// TestKuaiShou_CacheError 测试缓存读取失败时返回错误 不会请求接口刷新token
+func TestKuaiShou_CacheError(t *testing.T) {
+	requests := 0
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		Cache:     &failingCache{Memory: cache.NewMemoryWithConfig(cache.MemoryConfig{})},
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			requests++
+			return jsonResponse(http.StatusOK, mockToken), nil
+		}),
+	})
+	if _, err := client.QueryOrder("123456"); err == nil || !strings.Contains(err.Error(), "connection refused") || requests != 0 {
+		t.Errorf("QueryOrder got a error %v requests %d", err, requests)
+	}
+}
+
+// TestKuaiShou_TokenRefresher 测试后台提前刷新token 刷新失败时继续使用旧token 以及 Close 后停止刷新
+func TestKuaiShou_TokenRefresher(t *testing.T) {
+	var refreshes, failing int32
+	memory := cache.NewMemory()
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		Cache:     memory,
+		// 缓存2秒后过期 token在1502秒后过期 提前量让后台每隔约30ms刷新一次
+		TokenRefresher: &accessToken.RefresherConfig{Ahead: 1502*time.Second - 30*time.Millisecond, RetryInterval: 10 * time.Millisecond},
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if atomic.LoadInt32(&failing) == 1 {
+				return jsonResponse(http.StatusBadGateway, `bad gateway`), nil
+			}
+			count := atomic.AddInt32(&refreshes, 1)
+			return jsonResponse(http.StatusOK, fmt.Sprintf(`{"result":1,"access_token":"token_%d","expires_in":1502}`, count)), nil
+		}),
+	})
+	defer client.Close()
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&refreshes) < 2 {
+		t.Errorf("TokenRefresher should refresh ahead of expiry, got %d refreshes", refreshes)
+	}
+	token := client.AccessToken.(*accessToken.DefaultAccessToken)
+	if expiresAt := token.ExpiresAt(); time.Until(expiresAt) < 1500*time.Second || time.Until(expiresAt) > 1502*time.Second {
+		t.Errorf("ExpiresAt got %v", expiresAt)
+	}
+
+	// 刷新失败且缓存已经失效时 继续使用旧token
+	atomic.StoreInt32(&failing, 1)
+	time.Sleep(20 * time.Millisecond)
+	_ = memory.Delete(token.GetCacheKey())
+	if val, err := token.GetAccessToken(); err != nil || !strings.HasPrefix(val, "token_") {
+		t.Errorf("GetAccessToken should serve the stale token, got %s %v", val, err)
+	}
+	// 主动失效后旧token不再使用
+	_ = token.Invalidate()
+	if _, err := token.GetAccessToken(); err == nil {
+		t.Errorf("GetAccessToken should fail after Invalidate")
+	}
+
+	// 共用已经启动后台刷新的token管理时 忽略 ErrRefresherStarted 不记录日志
+	logger := &memoryLogger{}
+	NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:          "ks_test_app",
+		AppSecret:      "ks_test_secret",
+		AccessToken:    token,
+		Logger:         logger,
+		TokenRefresher: &accessToken.RefresherConfig{},
+	})
+	if len(logger.events) != 0 {
+		t.Errorf("NewKuaiShou got log events %+v", logger.events)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	_ = client.Close()
+	count := atomic.LoadInt32(&refreshes)
+	time.Sleep(60 * time.Millisecond)
+	if atomic.LoadInt32(&refreshes) != count {
+		t.Errorf("TokenRefresher should stop after Close")
+	}
+}
+
+// TestKuaiShou_ShortTokenCache 测试token的有效期比缓存提前过期的时间还短时 仍然缓存一段时间
+func TestKuaiShou_ShortTokenCache(t *testing.T) {
+	var refreshes int32
+	memory := cache.NewMemory()
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:     "ks_test_app",
+		AppSecret: "ks_test_secret",
+		Cache:     memory,
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&refreshes, 1)
+			return jsonResponse(http.StatusOK, `{"result":1,"access_token":"mock_token","expires_in":60}`), nil
+		}),
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := client.AccessToken.GetAccessToken(); err != nil {
+			t.Errorf("GetAccessToken got a error %s", err.Error())
+			return
+		}
+	}
+	ttl, err := cache.NewContextCache(memory).TTL(context.Background(), client.AccessToken.GetCacheKey())
+	if err != nil || ttl <= 0 || ttl > 30*time.Second || refreshes != 1 {
+		t.Errorf("TTL got %s %v after %d refreshes", ttl, err, refreshes)
+	}
+}
+
+// TestKuaiShou_TokenLocker 测试多个实例共享缓存与锁时 只有一个实例请求接口获取token
+func TestKuaiShou_TokenLocker(t *testing.T) {
+	var refreshes int32
+	shared := cache.NewMemory()
+	locker := &cache.CacheLocker{Cache: cache.NewContextCache(shared), RetryInterval: time.Millisecond}
+	httpClient := doerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == EndpointAccessToken {
+			atomic.AddInt32(&refreshes, 1)
+			time.Sleep(20 * time.Millisecond)
+			return jsonResponse(http.StatusOK, mockToken), nil
+		}
+		return jsonResponse(http.StatusOK, `{"result":1}`), nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		// 每个客户端模拟一个实例 各自有独立的进程内锁
+		client := NewKuaiShou(&KuaiShouAppletConfig{
+			AppId:       "ks_test_app",
+			AppSecret:   "ks_test_secret",
+			Cache:       shared,
+			HTTPClient:  httpClient,
+			TokenLocker: locker,
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := client.AccessToken.GetAccessToken(); err != nil || token != "mock_token" {
+				t.Errorf("GetAccessToken got %s %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if refreshes != 1 {
+		t.Errorf("TokenLocker should allow only one refresh, got %d", refreshes)
+	}
+}
+
+// TestKuaiShou_TokenLockerTTL 测试刷新token的耗时超过锁的有效期时 在锁过期前取消请求
+func TestKuaiShou_TokenLockerTTL(t *testing.T) {
+	shared := cache.NewMemory()
+	locker := &cache.CacheLocker{Cache: cache.NewContextCache(shared), TTL: 30 * time.Millisecond, RetryInterval: time.Millisecond}
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:       "ks_test_app",
+		AppSecret:   "ks_test_secret",
+		Cache:       shared,
+		TokenLocker: locker,
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(time.Second):
+				return jsonResponse(http.StatusOK, mockToken), nil
+			}
+		}),
+	})
+	start := time.Now()
+	if _, err := client.AccessToken.GetAccessToken(); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("GetAccessToken got a error %v after %s", err, time.Since(start))
+	}
+}
+
+// TestKuaiShou_SecretProvider 测试密钥从文件读取 修改文件后签名与获取token使用新密钥 宽限期内回调接受旧密钥
+func TestKuaiShou_SecretProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app_secret")
+	_ = ioutil.WriteFile(path, []byte("secret_v1\n"), 0600)
+	var secrets []string
+	provider := util.NewRotatingSecret(util.NewFileSecret(path), 50*time.Millisecond)
+	client := NewKuaiShou(&KuaiShouAppletConfig{
+		AppId:          "ks_test_app",
+		SecretProvider: provider,
+		HTTPClient: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == EndpointAccessToken {
+				_ = req.ParseForm()
+				secrets = append(secrets, req.PostForm.Get("app_secret"))
+				return jsonResponse(http.StatusOK, mockToken), nil
+			}
+			return jsonResponse(http.StatusOK, `{"result":1}`), nil
+		}),
+	})
+	body := `{"data":{"status":"SUCCESS"},"biz_type":"PAYMENT","app_id":"ks_test_app"}`
+	sign := func(secret string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(body+secret)))
+	}
+	_, _ = client.QueryOrder("123456")
+	oldSign := client.GenerateSign(map[string]interface{}{"out_order_no": "123456"})
+
+	// 轮换密钥 不需要重新实例化客户端
+	_ = ioutil.WriteFile(path, []byte("secret_v2_rotated\n"), 0600)
+	_ = client.AccessToken.Invalidate()
+	_, _ = client.QueryOrder("123456")
+	if fmt.Sprint(secrets) != "[secret_v1 secret_v2_rotated]" {
+		t.Errorf("token fetch got secrets %v", secrets)
+	}
+	if newSign := client.GenerateSign(map[string]interface{}{"out_order_no": "123456"}); newSign == oldSign {
+		t.Errorf("GenerateSign should use the rotated secret")
+	}
+	if err := client.CallbackCheckSignature(sign("secret_v2_rotated"), body); err != nil {
+		t.Errorf("CallbackCheckSignature got a error %s", err.Error())
+	}
+	if err := client.CallbackCheckSignature(sign("secret_v1"), body); err != nil {
+		t.Errorf("CallbackCheckSignature should accept the old secret during the grace period, got %s", err.Error())
+	}
+	if err := client.CallbackCheckSignature(sign("secret_wrong"), body); !errors.Is(err, ErrSignatureFailed) {
+		t.Errorf("CallbackCheckSignature should reject a wrong secret, got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := client.CallbackCheckSignature(sign("secret_v1"), body); err == nil {
+		t.Errorf("CallbackCheckSignature should reject the old secret after the grace period")
+	}
+
+	// 重启后通过 Previous 继续接受旧密钥
+	restarted := util.NewRotatingSecret(util.NewFileSecret(path), time.Minute)
+	restarted.Previous = "secret_v1"
+	if secrets, err := restarted.Secrets(context.Background()); err != nil || fmt.Sprint(secrets) != "[secret_v2_rotated secret_v1]" {
+		t.Errorf("Secrets got %v %v", secrets, err)
+	}
+
+	// 读取密钥失败时 GenerateSign 返回空字符串并记录日志
+	logger := &memoryLogger{}
+	client.Logger = logger
+	_ = os.Remove(path)
+	if _, err := client.GenerateSignWithContext(context.Background(), map[string]interface{}{"out_order_no": "123456"}); err == nil {
+		t.Errorf("GenerateSignWithContext should fail without a secret")
+	}
+	if sign := client.GenerateSign(map[string]interface{}{"out_order_no": "123456"}); sign != "" || len(logger.events) != 1 || logger.events[0].Error == "" {
+		t.Errorf("GenerateSign got %s events %+v", sign, logger.events)
+	}
+}
@@ -0,0 +1,137 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// redactedValue 脱敏后的值
+const redactedValue = "******"
+
+// redactedKeys 日志中需要脱敏的字段
+var redactedKeys = map[string]bool{
+	"app_secret":    true,
+	"access_token":  true,
+	"refresh_token": true,
+	"sign":          true,
+	"session_key":   true,
+}
+
+// redactedPattern 匹配文本中 key=value 形式的敏感字段 如错误信息中的请求地址
+var redactedPattern = regexp.MustCompile(`(app_secret|access_token|refresh_token|sign|session_key)=[^&\s"]*`)
+
+// LogEvent 一次接口请求的日志 所有敏感字段都已经脱敏
+type LogEvent struct {
+	Endpoint    string                 // 接口名称
+	URL         string                 // 请求地址
+	Attempt     int                    // 第几次尝试
+	Duration    time.Duration          // 请求耗时
+	StatusCode  int                    // http状态码 请求失败时为0 被限流拒绝时为 StatusRateLimited
+	Result      int                    // 快手返回的 result
+	ErrorMsg    string                 // 快手返回的 error_msg
+	OutOrderNo  string                 // 商户订单号
+	OutRefundNo string                 // 商户退款单号
+	OutSettleNo string                 // 商户结算单号
+	Params      map[string]interface{} // 请求参数
+	Response    map[string]interface{} // 返回值
+	Error       string                 // 错误信息 成功时为空
+}
+
+// Logger 日志接口 每次接口请求结束后调用
+type Logger interface {
+	Log(ctx context.Context, event LogEvent)
+}
+
+// StdLogger 使用标准库 log 输出日志
+type StdLogger struct {
+	Logger *log.Logger // 为nil时使用 log 包默认的输出
+}
+
+// NewStdLogger 实例化一个标准库 log 的适配器 logger为nil时使用 log 包默认的输出
+func NewStdLogger(logger *log.Logger) Logger {
+	return &StdLogger{Logger: logger}
+}
+
+// Log 输出一行日志
+func (l *StdLogger) Log(ctx context.Context, event LogEvent) {
+	line := fmt.Sprintf("kuaishou endpoint=%s attempt=%d duration=%s status=%d result=%d out_order_no=%s out_refund_no=%s out_settle_no=%s error_msg=%q error=%q",
+		event.Endpoint, event.Attempt, event.Duration, event.StatusCode, event.Result, event.OutOrderNo, event.OutRefundNo, event.OutSettleNo, event.ErrorMsg, event.Error)
+	if l.Logger == nil {
+		log.Println(line)
+		return
+	}
+	l.Logger.Println(line)
+}
+
+// loggingMiddleware 记录每次请求的日志
+func loggingMiddleware(logger Logger) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			res, err := next(ctx, req)
+			event := LogEvent{
+				Endpoint: req.Endpoint,
+				URL:      RedactText(req.URL),
+				Attempt:  req.Attempt,
+				Duration: time.Since(start),
+				Params:   redactMap(req.Params),
+			}
+			event.OutOrderNo, event.OutRefundNo, event.OutSettleNo = outNumbers(req.Params)
+			if res != nil {
+				event.StatusCode, event.Result, event.ErrorMsg = res.StatusCode, res.Result, res.ErrorMsg
+				var response map[string]interface{}
+				if json.Unmarshal(res.Body, &response) == nil {
+					event.Response = redactMap(response)
+				}
+			}
+			if errors.Is(err, ErrRateLimited) {
+				event.StatusCode = StatusRateLimited
+			}
+			if err != nil {
+				event.Error = RedactText(err.Error())
+			}
+			logger.Log(ctx, event)
+			return res, err
+		}
+	}
+}
+
+// outNumbers 获取请求参数中的商户订单号 退款单号 结算单号
+func outNumbers(params map[string]interface{}) (outOrderNo, outRefundNo, outSettleNo string) {
+	get := func(key string) string {
+		if val, ok := params[key]; ok && val != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return ""
+	}
+	return get("out_order_no"), get("out_refund_no"), get("out_settle_no")
+}
+
+// RedactText 把文本中 access_token=xxx 这类敏感字段替换为 ******
+func RedactText(text string) string {
+	return redactedPattern.ReplaceAllString(text, "$1="+redactedValue)
+}
+
+// redactMap 复制一份map并脱敏其中的敏感字段 嵌套的map同样处理
+func redactMap(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(values))
+	for key, val := range values {
+		if redactedKeys[key] {
+			redacted[key] = redactedValue
+			continue
+		}
+		if nested, ok := val.(map[string]interface{}); ok {
+			val = redactMap(nested)
+		}
+		redacted[key] = val
+	}
+	return redacted
+}
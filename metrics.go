@@ -0,0 +1,261 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	accessToken "github.com/HeartGarlic/kuaishou-server-api-sdk/access-token"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics 监控接口 统计接口请求与token的获取情况
+type Metrics interface {
+	accessToken.Observer
+	// ObserveRequest 每次接口请求结束后调用 statusCode 请求失败时为0 被限流拒绝时为 StatusRateLimited result 为快手返回的result
+	ObserveRequest(endpoint string, statusCode int, result int, duration time.Duration)
+}
+
+// metricsMiddleware 统计每次请求的结果与耗时
+func metricsMiddleware(metrics Metrics) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			start := time.Now()
+			res, err := next(ctx, req)
+			statusCode, result := 0, 0
+			if res != nil {
+				statusCode, result = res.StatusCode, res.Result
+			}
+			if errors.Is(err, ErrRateLimited) {
+				statusCode = StatusRateLimited
+			}
+			metrics.ObserveRequest(req.Endpoint, statusCode, result, time.Since(start))
+			return res, err
+		}
+	}
+}
+
+// statusLabel 状态码在统计中的名称 被限流拒绝时为 rate_limited
+func statusLabel(statusCode int) string {
+	if statusCode == StatusRateLimited {
+		return "rate_limited"
+	}
+	return strconv.Itoa(statusCode)
+}
+
+// DefaultDurationBuckets 默认的耗时分布区间 单位秒
+var DefaultDurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey 请求次数的统计维度
+type requestKey struct {
+	endpoint   string
+	statusCode int
+	result     int
+}
+
+// histogram 耗时分布
+type histogram struct {
+	counts []uint64 // 每个区间的次数 最后一个是 +Inf
+	sum    float64
+	count  uint64
+}
+
+// MemoryMetrics 不依赖第三方库的 Metrics 默认实现
+// 实现了 http.Handler 输出 Prometheus 文本格式 也实现了 expvar.Var 可以通过 expvar.Publish 暴露
+type MemoryMetrics struct {
+	lock          sync.Mutex
+	buckets       []float64
+	requests      map[requestKey]uint64
+	durations     map[string]*histogram
+	tokenRefresh  map[bool]uint64
+	tokenDuration *histogram
+	tokenCache    map[bool]uint64
+}
+
+// NewMemoryMetrics 实例化一个内存统计 buckets为空时使用 DefaultDurationBuckets
+func NewMemoryMetrics(buckets ...float64) *MemoryMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &MemoryMetrics{
+		buckets:       buckets,
+		requests:      map[requestKey]uint64{},
+		durations:     map[string]*histogram{},
+		tokenRefresh:  map[bool]uint64{},
+		tokenDuration: &histogram{counts: make([]uint64, len(buckets)+1)},
+		tokenCache:    map[bool]uint64{},
+	}
+}
+
+// ObserveRequest 记录一次接口请求
+func (m *MemoryMetrics) ObserveRequest(endpoint string, statusCode int, result int, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[requestKey{endpoint: endpoint, statusCode: statusCode, result: result}]++
+	h, ok := m.durations[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets)+1)}
+		m.durations[endpoint] = h
+	}
+	m.observe(h, duration)
+}
+
+// ObserveTokenRefresh 记录一次请求接口刷新token
+func (m *MemoryMetrics) ObserveTokenRefresh(duration time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tokenRefresh[err == nil]++
+	m.observe(m.tokenDuration, duration)
+}
+
+// ObserveTokenCache 记录一次从缓存读取token
+func (m *MemoryMetrics) ObserveTokenCache(hit bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tokenCache[hit]++
+}
+
+// TokenCacheHitRatio token缓存命中率 没有读取过时返回0
+func (m *MemoryMetrics) TokenCacheHitRatio() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.hitRatio()
+}
+
+// observe 把耗时记录到分布中 调用方需要持有锁
+func (m *MemoryMetrics) observe(h *histogram, duration time.Duration) {
+	seconds := duration.Seconds()
+	index := sort.SearchFloat64s(m.buckets, seconds)
+	h.counts[index]++
+	h.sum += seconds
+	h.count++
+}
+
+// hitRatio 计算缓存命中率 调用方需要持有锁
+func (m *MemoryMetrics) hitRatio() float64 {
+	total := m.tokenCache[true] + m.tokenCache[false]
+	if total == 0 {
+		return 0
+	}
+	return float64(m.tokenCache[true]) / float64(total)
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出统计数据
+func (m *MemoryMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(m.Text()))
+}
+
+// Text 以 Prometheus 文本格式返回统计数据
+func (m *MemoryMetrics) Text() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	builder := &strings.Builder{}
+
+	builder.WriteString("# HELP kuaishou_requests_total Total number of Kuaishou API requests.\n")
+	builder.WriteString("# TYPE kuaishou_requests_total counter\n")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		if keys[i].statusCode != keys[j].statusCode {
+			return keys[i].statusCode < keys[j].statusCode
+		}
+		return keys[i].result < keys[j].result
+	})
+	for _, key := range keys {
+		fmt.Fprintf(builder, "kuaishou_requests_total{endpoint=%q,status=%q,result=\"%d\"} %d\n", key.endpoint, statusLabel(key.statusCode), key.result, m.requests[key])
+	}
+
+	builder.WriteString("# HELP kuaishou_request_duration_seconds Kuaishou API request latency.\n")
+	builder.WriteString("# TYPE kuaishou_request_duration_seconds histogram\n")
+	endpoints := make([]string, 0, len(m.durations))
+	for endpoint := range m.durations {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		m.writeHistogram(builder, "kuaishou_request_duration_seconds", fmt.Sprintf("endpoint=%q", endpoint), m.durations[endpoint])
+	}
+
+	builder.WriteString("# HELP kuaishou_token_refresh_total Total number of access_token fetches from the server.\n")
+	builder.WriteString("# TYPE kuaishou_token_refresh_total counter\n")
+	fmt.Fprintf(builder, "kuaishou_token_refresh_total{status=\"success\"} %d\n", m.tokenRefresh[true])
+	fmt.Fprintf(builder, "kuaishou_token_refresh_total{status=\"error\"} %d\n", m.tokenRefresh[false])
+	builder.WriteString("# HELP kuaishou_token_refresh_duration_seconds Latency of access_token fetches.\n")
+	builder.WriteString("# TYPE kuaishou_token_refresh_duration_seconds histogram\n")
+	m.writeHistogram(builder, "kuaishou_token_refresh_duration_seconds", "", m.tokenDuration)
+
+	builder.WriteString("# HELP kuaishou_token_cache_total Total number of access_token cache lookups.\n")
+	builder.WriteString("# TYPE kuaishou_token_cache_total counter\n")
+	fmt.Fprintf(builder, "kuaishou_token_cache_total{status=\"hit\"} %d\n", m.tokenCache[true])
+	fmt.Fprintf(builder, "kuaishou_token_cache_total{status=\"miss\"} %d\n", m.tokenCache[false])
+	builder.WriteString("# HELP kuaishou_token_cache_hit_ratio Ratio of access_token cache hits.\n")
+	builder.WriteString("# TYPE kuaishou_token_cache_hit_ratio gauge\n")
+	fmt.Fprintf(builder, "kuaishou_token_cache_hit_ratio %g\n", m.hitRatio())
+	return builder.String()
+}
+
+// writeHistogram 输出一个分布 调用方需要持有锁
+func (m *MemoryMetrics) writeHistogram(builder *strings.Builder, name, labels string, h *histogram) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+	var cumulative uint64
+	for i, bucket := range m.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(builder, "%s_bucket{%sle=\"%g\"} %d\n", name, prefix, bucket, cumulative)
+	}
+	fmt.Fprintf(builder, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(builder, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(builder, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// String 以json格式返回统计数据 实现 expvar.Var
+func (m *MemoryMetrics) String() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	type endpointStat struct {
+		Requests map[string]uint64 `json:"requests"` // key为 status/result
+		Count    uint64            `json:"count"`
+		Seconds  float64           `json:"seconds"`
+	}
+	endpoints := map[string]*endpointStat{}
+	for key, count := range m.requests {
+		stat, ok := endpoints[key.endpoint]
+		if !ok {
+			stat = &endpointStat{Requests: map[string]uint64{}}
+			endpoints[key.endpoint] = stat
+		}
+		stat.Requests[fmt.Sprintf("%s/%d", statusLabel(key.statusCode), key.result)] += count
+	}
+	for endpoint, h := range m.durations {
+		if stat, ok := endpoints[endpoint]; ok {
+			stat.Count, stat.Seconds = h.count, h.sum
+		}
+	}
+	marshal, _ := json.Marshal(map[string]interface{}{
+		"endpoints":             endpoints,
+		"token_refresh_success": m.tokenRefresh[true],
+		"token_refresh_error":   m.tokenRefresh[false],
+		"token_cache_hit":       m.tokenCache[true],
+		"token_cache_miss":      m.tokenCache[false],
+		"token_cache_hit_ratio": m.hitRatio(),
+	})
+	return string(marshal)
+}
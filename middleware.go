@@ -0,0 +1,24 @@
+package kuaishou_server_api_sdk
+
+import (
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+)
+
+// Request 一次接口请求 见 util.Request
+type Request = util.Request
+
+// Response 接口的返回值 见 util.Response
+type Response = util.Response
+
+// RoundTripFunc 执行一次请求 见 util.RoundTripFunc
+type RoundTripFunc = util.RoundTripFunc
+
+// Middleware 请求中间件 可以在请求前后记录日志 统计耗时 注入请求头 或者在测试中模拟故障
+//
+//	func(next RoundTripFunc) RoundTripFunc {
+//		return func(ctx context.Context, req *Request) (*Response, error) {
+//			req.Header.Set("X-Request-Id", requestId)
+//			return next(ctx, req)
+//		}
+//	}
+type Middleware = util.Middleware
@@ -0,0 +1,305 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 开放平台用户授权的接口路径
+const (
+	EndpointAuthorize    = "/oauth2/authorize"     // 用户授权页面
+	EndpointAccessToken  = "/oauth2/access_token"  // 使用code获取token
+	EndpointRefreshToken = "/oauth2/refresh_token" // 刷新token
+	EndpointUserInfo     = "/openapi/user_info"    // 获取用户信息
+)
+
+// 默认配置
+const (
+	defaultScope        = "user_info"
+	defaultRefreshAhead = 5 * time.Minute
+)
+
+// Config 开放平台用户授权的配置
+type Config struct {
+	AppId          string              // 开放平台应用的 app_id
+	AppSecret      string              // 开放平台应用的 app_secret
+	SecretProvider util.SecretProvider // 每次请求时读取 app_secret 支持不重启轮换 为nil时使用 AppSecret
+	RedirectURI    string              // 授权后跳转的地址 需要与开放平台配置的一致
+	Scopes         []string            // 授权范围 为空时使用 user_info
+	BaseApiHost    string              // api基础地址 为空时使用 util.DefaultBaseApiHost
+	HTTPClient     util.Doer           // http客户端 为空时使用 util.DefaultHTTPClient
+	Middlewares    []util.Middleware   // 请求中间件
+	Store          TokenStore          // 保存用户token 为空时使用内存缓存
+	Locker         cache.Locker        // 刷新用户token时使用的锁 多个实例共享 Store 时设置 为nil时只在进程内加锁
+	RefreshAhead   time.Duration       // AccessToken 过期前多久刷新 为0时使用5分钟
+}
+
+// OAuth 快手开放平台的用户授权
+// 生成授权地址 使用code换取token 按 open_id 保存并自动刷新token 获取用户信息
+type OAuth struct {
+	config      Config
+	roundTrip   util.RoundTripFunc
+	refreshLock sync.Mutex               // 保护 refreshing
+	refreshing  map[string]chan struct{} // 正在刷新的 open_id 刷新结束后关闭
+}
+
+// NewOAuth 实例化用户授权
+func NewOAuth(config *Config) *OAuth {
+	o := &OAuth{config: *config, refreshing: map[string]chan struct{}{}}
+	if len(o.config.Scopes) == 0 {
+		o.config.Scopes = []string{defaultScope}
+	}
+	if o.config.Store == nil {
+		o.config.Store = NewCacheStore(cache.NewMemory())
+	}
+	if o.config.RefreshAhead <= 0 {
+		o.config.RefreshAhead = defaultRefreshAhead
+	}
+	if o.config.SecretProvider == nil {
+		o.config.SecretProvider = util.StaticSecret(o.config.AppSecret)
+	}
+	o.roundTrip = util.Chain(util.Transport(o.config.HTTPClient), o.config.Middlewares...)
+	return o
+}
+
+// NewState 生成一个随机的state 需要保存在用户的会话中 回调时校验防止CSRF
+func NewState() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// AuthorizeURL 生成用户授权页面的地址
+func (o *OAuth) AuthorizeURL(state string) string {
+	query := url.Values{}
+	query.Set("app_id", o.config.AppId)
+	query.Set("scope", strings.Join(o.config.Scopes, ","))
+	query.Set("response_type", "code")
+	query.Set("redirect_uri", o.config.RedirectURI)
+	query.Set("state", state)
+	return util.JoinURL(o.config.BaseApiHost, EndpointAuthorize) + "?" + query.Encode()
+}
+
+// tokenResponse 获取与刷新token的返回值
+type tokenResponse struct {
+	Result                int      `json:"result"`
+	ErrorMsg              string   `json:"error_msg"`
+	AccessToken           string   `json:"access_token"`
+	ExpiresIn             int      `json:"expires_in"`
+	RefreshToken          string   `json:"refresh_token"`
+	RefreshTokenExpiresIn int      `json:"refresh_token_expires_in"`
+	OpenId                string   `json:"open_id"`
+	Scopes                []string `json:"scopes"`
+}
+
+// toToken 转换为 Token start为请求发出的时间
+func (res *tokenResponse) toToken(start time.Time) *Token {
+	return &Token{
+		OpenId:                res.OpenId,
+		AccessToken:           res.AccessToken,
+		RefreshToken:          res.RefreshToken,
+		Scopes:                res.Scopes,
+		ExpiresAt:             start.Add(time.Duration(res.ExpiresIn) * time.Second),
+		RefreshTokenExpiresAt: start.Add(time.Duration(res.RefreshTokenExpiresIn) * time.Second),
+	}
+}
+
+// Exchange 使用授权回调中的code换取用户的token 并保存到 Store
+func (o *OAuth) Exchange(ctx context.Context, code string) (*Token, error) {
+	secret, err := o.config.SecretProvider.Secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var res tokenResponse
+	err = o.get(ctx, EndpointAccessToken, map[string]interface{}{
+		"app_id":     o.config.AppId,
+		"app_secret": secret,
+		"code":       code,
+		"grant_type": "authorization_code",
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	token := res.toToken(start)
+	if err = o.config.Store.Save(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Token 获取用户的token AccessToken 即将过期时自动刷新
+func (o *OAuth) Token(ctx context.Context, openId string) (*Token, error) {
+	token, err := o.config.Store.Get(ctx, openId)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Expired(o.config.RefreshAhead) {
+		return token, nil
+	}
+	refreshed, err := o.refresh(ctx, openId, false)
+	// 刷新失败但token还没有过期时 继续使用
+	if err != nil && !errors.Is(err, ErrTokenNotFound) && !token.Expired(0) {
+		return token, nil
+	}
+	return refreshed, err
+}
+
+// Refresh 立即使用 RefreshToken 刷新用户的token
+func (o *OAuth) Refresh(ctx context.Context, openId string) (*Token, error) {
+	return o.refresh(ctx, openId, true)
+}
+
+// Revoke 删除保存的用户token 用户需要重新授权
+func (o *OAuth) Revoke(ctx context.Context, openId string) error {
+	return o.config.Store.Delete(ctx, openId)
+}
+
+// refresh 加锁后刷新token force为false时 其他请求已经刷新过就直接返回
+func (o *OAuth) refresh(ctx context.Context, openId string, force bool) (*Token, error) {
+	release, err := o.lockOpenId(ctx, openId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if o.config.Locker != nil {
+		unlock, err := o.config.Locker.Lock(ctx, "kuaishou_server_api_sdk_oauth_refresh_"+openId)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = unlock()
+		}()
+	}
+	// 双检 RefreshToken 可能只能使用一次 不能重复刷新
+	token, err := o.config.Store.Get(ctx, openId)
+	if err != nil {
+		return nil, err
+	}
+	if !force && !token.Expired(o.config.RefreshAhead) {
+		return token, nil
+	}
+	if !time.Now().Before(token.RefreshTokenExpiresAt) {
+		_ = o.config.Store.Delete(ctx, openId)
+		return nil, ErrTokenNotFound
+	}
+	secret, err := o.config.SecretProvider.Secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var res tokenResponse
+	err = o.get(ctx, EndpointRefreshToken, map[string]interface{}{
+		"app_id":        o.config.AppId,
+		"app_secret":    secret,
+		"refresh_token": token.RefreshToken,
+		"grant_type":    "refresh_token",
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	refreshed := res.toToken(start)
+	if refreshed.OpenId == "" {
+		refreshed.OpenId = openId
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken, refreshed.RefreshTokenExpiresAt = token.RefreshToken, token.RefreshTokenExpiresAt
+	}
+	if len(refreshed.Scopes) == 0 {
+		refreshed.Scopes = token.Scopes
+	}
+	if err = o.config.Store.Save(ctx, refreshed); err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
+
+// lockOpenId 按 open_id 加进程内的锁 不同用户的刷新互不影响 等待时ctx取消或超时直接返回
+func (o *OAuth) lockOpenId(ctx context.Context, openId string) (release func(), err error) {
+	for {
+		o.refreshLock.Lock()
+		done, ok := o.refreshing[openId]
+		if !ok {
+			done = make(chan struct{})
+			o.refreshing[openId] = done
+			o.refreshLock.Unlock()
+			return func() {
+				o.refreshLock.Lock()
+				delete(o.refreshing, openId)
+				o.refreshLock.Unlock()
+				close(done)
+			}, nil
+		}
+		o.refreshLock.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// UserInfo 用户的基础信息
+type UserInfo struct {
+	Name    string `json:"name"`    // 昵称
+	Sex     string `json:"sex"`     // 性别 M男 F女
+	Fan     int    `json:"fan"`     // 粉丝数
+	Follow  int    `json:"follow"`  // 关注数
+	Head    string `json:"head"`    // 头像
+	BigHead string `json:"bigHead"` // 大头像
+	City    string `json:"city"`    // 城市
+}
+
+// UserInfo 使用用户的token获取用户的基础信息 需要 user_info 授权范围
+func (o *OAuth) UserInfo(ctx context.Context, openId string) (*UserInfo, error) {
+	token, err := o.Token(ctx, openId)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Result   int      `json:"result"`
+		ErrorMsg string   `json:"error_msg"`
+		UserInfo UserInfo `json:"user_info"`
+	}
+	err = o.get(ctx, EndpointUserInfo, map[string]interface{}{
+		"app_id":       o.config.AppId,
+		"access_token": token.AccessToken,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res.UserInfo, nil
+}
+
+// get 以GET方式请求接口 返回的错误中不包含query参数
+func (o *OAuth) get(ctx context.Context, endpoint string, params map[string]interface{}, out interface{}) error {
+	_, err := o.roundTrip(ctx, &util.Request{
+		Endpoint: endpoint,
+		URL:      util.JoinURL(o.config.BaseApiHost, endpoint),
+		Method:   http.MethodGet,
+		Params:   params,
+		Header:   http.Header{},
+		Attempt:  1,
+		Out:      out,
+	})
+	// 请求失败时错误中的地址包含 app_secret 与 refresh_token 去掉query参数
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		if parse, parseErr := url.Parse(urlError.URL); parseErr == nil {
+			parse.RawQuery = ""
+			urlError.URL = parse.String()
+		}
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestOAuth 测试授权地址 code换取token 自动刷新以及获取用户信息
+func TestOAuth(t *testing.T) {
+	var refreshes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Method != http.MethodGet || query.Get("app_id") != "ks_test_app" {
+			t.Errorf("got request %s %s", r.Method, r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case EndpointAccessToken:
+			if query.Get("code") != "auth_code" || query.Get("grant_type") != "authorization_code" || query.Get("app_secret") != "ks_test_secret" {
+				_, _ = w.Write([]byte(`{"result":100200100,"error_msg":"invalid code"}`))
+				return
+			}
+			// expires_in 很短 下一次获取时需要刷新
+			_, _ = w.Write([]byte(`{"result":1,"access_token":"user_token_0","expires_in":60,"refresh_token":"refresh_0","refresh_token_expires_in":2592000,"open_id":"open_1","scopes":["user_info"]}`))
+		case EndpointRefreshToken:
+			count := atomic.AddInt32(&refreshes, 1)
+			if query.Get("refresh_token") != fmt.Sprintf("refresh_%d", count-1) {
+				t.Errorf("got refresh_token %s", query.Get("refresh_token"))
+			}
+			_, _ = fmt.Fprintf(w, `{"result":1,"access_token":"user_token_%d","expires_in":7200,"refresh_token":"refresh_%d","refresh_token_expires_in":2592000}`, count, count)
+		case EndpointUserInfo:
+			if query.Get("access_token") != "user_token_1" {
+				_, _ = w.Write([]byte(`{"result":100200101,"error_msg":"invalid token"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"result":1,"user_info":{"name":"kuaishou","sex":"M","fan":10,"follow":2,"head":"https://example.com/head.png","city":"北京"}}`))
+		}
+	}))
+	defer server.Close()
+
+	o := NewOAuth(&Config{
+		AppId:       "ks_test_app",
+		AppSecret:   "ks_test_secret",
+		RedirectURI: "https://example.com/callback",
+		BaseApiHost: server.URL,
+	})
+	state, err := NewState()
+	if err != nil || len(state) != 32 {
+		t.Errorf("NewState got %s %v", state, err)
+	}
+	authorize, _ := url.Parse(o.AuthorizeURL(state))
+	if authorize.Path != EndpointAuthorize || authorize.Query().Get("state") != state || authorize.Query().Get("scope") != "user_info" || authorize.Query().Get("redirect_uri") != "https://example.com/callback" {
+		t.Errorf("AuthorizeURL got %s", authorize)
+	}
+
+	ctx := context.Background()
+	if _, err = o.Exchange(ctx, "wrong_code"); err == nil {
+		t.Errorf("Exchange should fail with a wrong code")
+	}
+	token, err := o.Exchange(ctx, "auth_code")
+	if err != nil || token.OpenId != "open_1" || token.AccessToken != "user_token_0" {
+		t.Errorf("Exchange got %+v %v", token, err)
+		return
+	}
+	// AccessToken 在 RefreshAhead 内过期 获取用户信息前自动刷新
+	info, err := o.UserInfo(ctx, "open_1")
+	if err != nil || info.Name != "kuaishou" || info.Fan != 10 {
+		t.Errorf("UserInfo got %+v %v", info, err)
+		return
+	}
+	token, _ = o.Token(ctx, "open_1")
+	if token.AccessToken != "user_token_1" || token.RefreshToken != "refresh_1" || token.OpenId != "open_1" || len(token.Scopes) != 1 || refreshes != 1 {
+		t.Errorf("Token got %+v after %d refreshes", token, refreshes)
+	}
+	if token, _ = o.Refresh(ctx, "open_1"); token.AccessToken != "user_token_2" || time.Until(token.ExpiresAt) < time.Hour {
+		t.Errorf("Refresh got %+v", token)
+	}
+
+	_ = o.Revoke(ctx, "open_1")
+	if _, err = o.Token(ctx, "open_1"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Token should return ErrTokenNotFound after Revoke, got %v", err)
+	}
+}
+
+// TestCacheStore 测试保存已经过期的token时删除旧的token
+func TestCacheStore(t *testing.T) {
+	store := NewCacheStore(cache.NewMemory())
+	ctx := context.Background()
+	token := &Token{OpenId: "open_1", AccessToken: "user_token", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(ctx, token); err != nil {
+		t.Errorf("Save got a error %s", err.Error())
+		return
+	}
+	token.ExpiresAt = time.Now().Add(-time.Second)
+	if err := store.Save(ctx, token); err != nil {
+		t.Errorf("Save got a error %s", err.Error())
+	}
+	if _, err := store.Get(ctx, "open_1"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Get should return ErrTokenNotFound for an expired token, got %v", err)
+	}
+}
+
+// TestOAuth_Refresh 测试不同用户的刷新互不阻塞 等待刷新锁时ctx超时直接返回 以及错误中不包含密钥
+func TestOAuth_Refresh(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshToken := r.URL.Query().Get("refresh_token")
+		if refreshToken == "refresh_a" {
+			<-release
+		}
+		_, _ = fmt.Fprintf(w, `{"result":1,"access_token":"token_%s","expires_in":7200}`, refreshToken)
+	}))
+	defer server.Close()
+	store := NewCacheStore(cache.NewMemory())
+	ctx := context.Background()
+	for _, openId := range []string{"a", "b"} {
+		_ = store.Save(ctx, &Token{OpenId: openId, AccessToken: "old", RefreshToken: "refresh_" + openId, ExpiresAt: time.Now().Add(time.Minute), RefreshTokenExpiresAt: time.Now().Add(time.Hour)})
+	}
+	o := NewOAuth(&Config{AppId: "ks_test_app", AppSecret: "ks_test_secret", BaseApiHost: server.URL, Store: store})
+
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := o.Refresh(ctx, "a")
+		refreshed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	timeout, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	if token, err := o.Refresh(timeout, "b"); err != nil || token.AccessToken != "token_refresh_b" {
+		t.Errorf("Refresh should not wait for other users, got %+v %v", token, err)
+	}
+	waiting, cancelWaiting := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelWaiting()
+	if _, err := o.Refresh(waiting, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Refresh should give up waiting, got %v", err)
+	}
+	close(release)
+	if err := <-refreshed; err != nil {
+		t.Errorf("Refresh got a error %s", err.Error())
+	}
+
+	// 请求失败时错误中不包含 app_secret 与 code
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	o = NewOAuth(&Config{AppId: "ks_test_app", AppSecret: "ks_test_secret", BaseApiHost: closed.URL})
+	if _, err := o.Exchange(ctx, "auth_code"); err == nil || strings.Contains(err.Error(), "ks_test_secret") || strings.Contains(err.Error(), "auth_code") {
+		t.Errorf("Exchange got a error %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"time"
+)
+
+// ErrTokenNotFound 用户没有授权或者授权已经过期
+var ErrTokenNotFound = errors.New("oauth: token not found")
+
+// Token 用户授权得到的token
+type Token struct {
+	OpenId                string    `json:"open_id"`                  // 用户的 open_id
+	AccessToken           string    `json:"access_token"`             // 调用用户相关接口的token
+	RefreshToken          string    `json:"refresh_token"`            // 刷新 AccessToken 使用
+	Scopes                []string  `json:"scopes"`                   // 用户授权的范围
+	ExpiresAt             time.Time `json:"expires_at"`               // AccessToken 的过期时间
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"` // RefreshToken 的过期时间
+}
+
+// Expired AccessToken 是否会在 margin 时间内过期
+func (t *Token) Expired(margin time.Duration) bool {
+	return !time.Now().Add(margin).Before(t.ExpiresAt)
+}
+
+// TokenStore 按 open_id 保存用户的token 可以使用 CacheStore 或者自行实现 如保存到数据库
+type TokenStore interface {
+	// Get 获取用户的token 不存在时返回 ErrTokenNotFound
+	Get(ctx context.Context, openId string) (*Token, error)
+	// Save 保存用户的token
+	Save(ctx context.Context, token *Token) error
+	// Delete 删除用户的token
+	Delete(ctx context.Context, openId string) error
+}
+
+// CacheStore 使用 cache.Cache 保存用户的token 保存到 RefreshToken 过期为止
+// token序列化为json字符串 可以使用redis 文件等任意缓存
+type CacheStore struct {
+	Cache  cache.Cache // 缓存
+	Prefix string      // key的前缀
+}
+
+// NewCacheStore 实例化一个使用缓存保存token的 TokenStore
+func NewCacheStore(c cache.Cache) *CacheStore {
+	return &CacheStore{
+		Cache:  c,
+		Prefix: "kuaishou_server_api_sdk_oauth_token_",
+	}
+}
+
+// Get 获取用户的token
+func (s *CacheStore) Get(ctx context.Context, openId string) (*Token, error) {
+	val, err := cache.NewContextCache(s.Cache).GetWithContext(ctx, s.Prefix+openId)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, ok := val.(string)
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	token := &Token{}
+	if err = json.Unmarshal([]byte(content), token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Save 保存用户的token
+func (s *CacheStore) Save(ctx context.Context, token *Token) error {
+	marshal, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	expiresAt := token.RefreshTokenExpiresAt
+	if expiresAt.Before(token.ExpiresAt) {
+		expiresAt = token.ExpiresAt
+	}
+	// token已经过期时不再保存 删除旧的token
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return s.Delete(ctx, token.OpenId)
+	}
+	return cache.NewContextCache(s.Cache).SetWithContext(ctx, s.Prefix+token.OpenId, string(marshal), ttl)
+}
+
+// Delete 删除用户的token
+func (s *CacheStore) Delete(ctx context.Context, openId string) error {
+	return cache.NewContextCache(s.Cache).DeleteWithContext(ctx, s.Prefix+openId)
+}
@@ -0,0 +1,127 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited 触发客户端限流 且 RateLimit.Wait 为false 或者等待超过了ctx的期限
+var ErrRateLimited = errors.New("kuaishou: rate limited")
+
+// StatusRateLimited 被客户端限流拒绝的请求在日志与监控中记录的状态码 与请求失败的0区分 请求没有发出
+const StatusRateLimited = -1
+
+// RateLimit 单个接口的限流配置 使用令牌桶算法
+type RateLimit struct {
+	Rate  float64 // 每秒生成的令牌数 即平均每秒允许的请求数
+	Burst int     // 桶的容量 即允许的突发请求数 小于1时按1处理
+	Wait  bool    // 令牌不足时是否阻塞等待 否则立即返回 ErrRateLimited
+}
+
+// tokenBucket 令牌桶 同一个客户端的所有goroutine共享
+type tokenBucket struct {
+	lock   sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 实例化一个装满令牌的桶
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+// take 尝试取出一个令牌 失败时返回需要等待的时间
+func (b *tokenBucket) take() (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if b.limit.Rate <= 0 {
+		return 0, false
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second)), false
+}
+
+// Wait 取出一个令牌 令牌不足时按配置阻塞等待或者返回 ErrRateLimited
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait, ok := b.take()
+		if ok {
+			return nil
+		}
+		if !b.limit.Wait || wait <= 0 {
+			return ErrRateLimited
+		}
+		// 等待时间超过ctx的期限时直接失败 不再空等
+		if deadline, has := ctx.Deadline(); has && time.Until(deadline) < wait {
+			return ErrRateLimited
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// rateLimiters 每个接口的令牌桶 可以在运行时通过 KuaiShou.SetRateLimit 修改
+type rateLimiters struct {
+	lock    sync.RWMutex
+	buckets map[string]*tokenBucket
+}
+
+// set 为接口重新创建令牌桶 limit为零值时取消限流
+func (r *rateLimiters) set(endpoint string, limit RateLimit) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if limit == (RateLimit{}) {
+		delete(r.buckets, endpoint)
+		return
+	}
+	if r.buckets == nil {
+		r.buckets = map[string]*tokenBucket{}
+	}
+	r.buckets[endpoint] = newTokenBucket(limit)
+}
+
+// get 获取接口的令牌桶 没有限流时返回false
+func (r *rateLimiters) get(endpoint string) (*tokenBucket, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	bucket, ok := r.buckets[endpoint]
+	return bucket, ok
+}
+
+// SetRateLimit 修改一个接口的限流配置 重新创建装满令牌的桶 limit为零值时取消该接口的限流
+// 可以在运行时调用 对之后的请求生效
+func (k *KuaiShou) SetRateLimit(endpoint string, limit RateLimit) {
+	k.rateLimiters.set(endpoint, limit)
+}
+
+// rateLimitMiddleware 请求前先从对应接口的令牌桶取出令牌
+func rateLimitMiddleware(limiters *rateLimiters) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			if limiter, ok := limiters.get(req.Endpoint); ok {
+				if err := limiter.Wait(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, req)
+		}
+	}
+}
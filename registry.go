@@ -0,0 +1,147 @@
+package kuaishou_server_api_sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/cache"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"sort"
+	"sync"
+)
+
+// ErrAppNotFound 注册中心中没有对应 app_id 的小程序
+var ErrAppNotFound = errors.New("kuaishou: app not found")
+
+// Registry 管理多个快手小程序的客户端
+// 所有小程序共用一个http客户端与一个缓存 缓存的key按 app_id 加前缀隔离
+type Registry struct {
+	lock    sync.RWMutex
+	shared  KuaiShouAppletConfig // 所有小程序共用的配置
+	clients map[string]*KuaiShou // key为 app_id
+}
+
+// NewRegistry 实例化一个注册中心 shared 中除 AppId AppSecret AccessToken SecretProvider 以外的配置会被所有小程序继承
+// shared 为nil时使用默认配置
+func NewRegistry(shared *KuaiShouAppletConfig) *Registry {
+	registry := &Registry{clients: map[string]*KuaiShou{}}
+	if shared != nil {
+		registry.shared = *shared
+	}
+	registry.shared.AppId, registry.shared.AppSecret, registry.shared.AccessToken = "", "", nil
+	registry.shared.SecretProvider = nil
+	if registry.shared.Cache == nil {
+		registry.shared.Cache = registry.shared.resolveCache()
+	}
+	registry.shared.ContextCache = nil
+	if registry.shared.HTTPClient == nil {
+		registry.shared.HTTPClient = util.DefaultHTTPClient
+	}
+	return registry
+}
+
+// Add 添加一个小程序 已存在相同 app_id 时替换
+// config 中未设置的字段继承共用配置 未设置缓存时使用带 app_id 前缀的共用缓存
+func (r *Registry) Add(config *KuaiShouAppletConfig) (*KuaiShou, error) {
+	if config == nil || config.AppId == "" {
+		return nil, fmt.Errorf("kuaishou: app_id is required")
+	}
+	merged := *config
+	shared := r.shared
+	if merged.Cache == nil && merged.ContextCache != nil {
+		merged.Cache = cache.FromContextCache(merged.ContextCache)
+	}
+	if merged.Cache == nil {
+		merged.Cache = cache.NewNamespace(shared.Cache, fmt.Sprintf("%s:", config.AppId))
+	}
+	if merged.BaseApiHost == "" {
+		merged.BaseApiHost = shared.BaseApiHost
+	}
+	if merged.EndpointHosts == nil {
+		merged.EndpointHosts = shared.EndpointHosts
+	}
+	if merged.HTTPClient == nil {
+		merged.HTTPClient = shared.HTTPClient
+	}
+	if merged.RetryPolicy == nil {
+		merged.RetryPolicy = shared.RetryPolicy
+	}
+	if merged.Middlewares == nil {
+		merged.Middlewares = shared.Middlewares
+	}
+	if merged.Logger == nil {
+		merged.Logger = shared.Logger
+	}
+	if merged.Metrics == nil {
+		merged.Metrics = shared.Metrics
+	}
+	if merged.Tracer == nil {
+		merged.Tracer = shared.Tracer
+	}
+	if merged.RateLimits == nil {
+		merged.RateLimits = shared.RateLimits
+	}
+	if merged.CircuitBreaker == nil {
+		merged.CircuitBreaker = shared.CircuitBreaker
+	}
+	if merged.TokenRefresher == nil {
+		merged.TokenRefresher = shared.TokenRefresher
+	}
+	if merged.TokenLocker == nil {
+		merged.TokenLocker = shared.TokenLocker
+	}
+	client := NewKuaiShou(&merged)
+	r.lock.Lock()
+	old := r.clients[config.AppId]
+	r.clients[config.AppId] = client
+	r.lock.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	return client, nil
+}
+
+// Remove 移除一个小程序 并停止它的token后台刷新
+func (r *Registry) Remove(appId string) {
+	r.lock.Lock()
+	client := r.clients[appId]
+	delete(r.clients, appId)
+	r.lock.Unlock()
+	if client != nil {
+		_ = client.Close()
+	}
+}
+
+// Get 获取一个小程序的客户端
+func (r *Registry) Get(appId string) (*KuaiShou, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if client, ok := r.clients[appId]; ok {
+		return client, nil
+	}
+	return nil, fmt.Errorf("%w : app_id=%s", ErrAppNotFound, appId)
+}
+
+// AppIds 获取所有小程序的 app_id 按字典序排列
+func (r *Registry) AppIds() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	appIds := make([]string, 0, len(r.clients))
+	for appId := range r.clients {
+		appIds = append(appIds, appId)
+	}
+	sort.Strings(appIds)
+	return appIds
+}
+
+// ResolveCallback 根据回调内容中的 app_id 找到对应的客户端
+// 找到后可以继续调用 PayCallbackResponse 等方法验签与解析
+func (r *Registry) ResolveCallback(body string) (*KuaiShou, error) {
+	var callback struct {
+		AppId string `json:"app_id"`
+	}
+	if err := json.Unmarshal([]byte(body), &callback); err != nil {
+		return nil, err
+	}
+	return r.Get(callback.AppId)
+}
@@ -0,0 +1,118 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+	"net/http"
+	"net/url"
+)
+
+// apiRequest 描述一次接口请求
+type apiRequest struct {
+	api        string                 // 接口路径 或者完整的接口地址
+	params     map[string]interface{} // 请求参数
+	form       bool                   // 是否以form表单提交 否则提交json
+	sign       bool                   // 是否需要对参数签名
+	withToken  bool                   // 是否需要在query中携带 app_id 与 access_token
+	idempotent bool                   // 重复请求是否没有副作用 只有幂等的请求才会按 RetryPolicy 重试
+}
+
+// do 所有接口统一的请求流程 幂等的请求遇到临时错误时按 RetryPolicy 重试
+// 设置了 Tracer 时整个调用开启一个span 每次尝试与刷新token的span都是它的子span
+func (k *KuaiShou) do(ctx context.Context, req apiRequest, out interface{}) (err error) {
+	endpoint := endpointName(req.api)
+	ctx, span := util.StartSpan(ctx, k.Tracer, "kuaishou "+endpoint)
+	defer span.End()
+	span.SetAttribute("kuaishou.endpoint", endpoint)
+	attempts := 0
+	err = k.RetryPolicy.Do(ctx, req.api, req.idempotent, func(attempt int) error {
+		attempts = attempt
+		return k.doWithTokenReplay(ctx, req, attempt, out)
+	})
+	span.SetAttribute("kuaishou.attempts", attempts)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return
+}
+
+// doWithTokenReplay 如果快手返回token无效 删除缓存中失效的token后重新获取 并重放一次请求
+func (k *KuaiShou) doWithTokenReplay(ctx context.Context, req apiRequest, attempt int, out interface{}) error {
+	token, err := k.doOnce(ctx, req, attempt, out)
+	if !req.withToken || !errors.Is(err, ErrInvalidToken) {
+		return err
+	}
+	// 只删除本次请求使用的token 缓存已经被其他请求刷新时直接使用新token重放
+	if err = k.AccessToken.InvalidateToken(token); err != nil {
+		return err
+	}
+	_, err = k.doOnce(ctx, req, attempt, out)
+	return err
+}
+
+// doOnce 获取token -> 签名 -> 经过中间件请求 -> 解析返回值 -> 校验result 返回本次请求使用的token
+func (k *KuaiShou) doOnce(ctx context.Context, req apiRequest, attempt int, out interface{}) (token string, err error) {
+	api := k.endpointURL(req.api)
+	if req.withToken {
+		// token获取失败直接返回 不再携带空的access_token请求
+		if token, err = k.AccessToken.GetAccessTokenWithContext(ctx); err != nil {
+			return
+		}
+		api = fmt.Sprintf("%s?%s", api, url.Values{"app_id": []string{k.AppId}, "access_token": []string{token}}.Encode())
+	}
+	if req.params == nil {
+		req.params = map[string]interface{}{}
+	}
+	if req.sign {
+		var sign string
+		if sign, err = k.GenerateSignWithContext(ctx, req.params); err != nil {
+			return
+		}
+		req.params["sign"] = sign
+	}
+	_, err = k.roundTrip()(ctx, &util.Request{
+		Endpoint: endpointName(req.api),
+		URL:      api,
+		Form:     req.form,
+		Params:   req.params,
+		Header:   http.Header{},
+		Attempt:  attempt,
+		Out:      out,
+	})
+	return
+}
+
+// roundTrip 把中间件包装到http请求外层
+func (k *KuaiShou) roundTrip() util.RoundTripFunc {
+	return util.Chain(util.Transport(k.HTTPClient), k.middlewares()...)
+}
+
+// middlewares 内置的中间件在最外层 之后是自定义的中间件
+func (k *KuaiShou) middlewares() []Middleware {
+	var middlewares []Middleware
+	if k.Tracer != nil {
+		middlewares = append(middlewares, tracingMiddleware(k.Tracer))
+	}
+	if k.Logger != nil {
+		middlewares = append(middlewares, loggingMiddleware(k.Logger))
+	}
+	if k.Metrics != nil {
+		middlewares = append(middlewares, metricsMiddleware(k.Metrics))
+	}
+	if len(k.circuitBreakers) > 0 {
+		middlewares = append(middlewares, circuitBreakerMiddleware(k.circuitBreakers))
+	}
+	// 限流可以在运行时通过 SetRateLimit 开启 总是加入中间件
+	middlewares = append(middlewares, rateLimitMiddleware(&k.rateLimiters))
+	return append(middlewares, k.Middlewares...)
+}
+
+// endpointURL 根据 BaseApiHost 与 EndpointHosts 拼接接口的完整地址
+func (k *KuaiShou) endpointURL(endpoint string) string {
+	if host, ok := k.EndpointHosts[endpoint]; ok {
+		return util.JoinURL(host, endpoint)
+	}
+	return util.JoinURL(k.BaseApiHost, endpoint)
+}
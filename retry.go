@@ -0,0 +1,18 @@
+package kuaishou_server_api_sdk
+
+import (
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+)
+
+// RetryPolicy 失败重试策略 见 util.RetryPolicy
+// 查询类接口与获取token总是可以重试
+// 下单 退款 结算只有在携带了 out_order_no out_refund_no out_settle_no 保证幂等时才会重试
+type RetryPolicy = util.RetryPolicy
+
+// RetryAttempt 一次尝试的结果 见 util.RetryAttempt
+type RetryAttempt = util.RetryAttempt
+
+// NewRetryPolicy 实例化一个默认的重试策略
+func NewRetryPolicy() *RetryPolicy {
+	return util.NewRetryPolicy()
+}
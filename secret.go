@@ -0,0 +1,9 @@
+package kuaishou_server_api_sdk
+
+import (
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+)
+
+// SecretProvider 提供 AppSecret 的接口 内置 util.StaticSecret util.EnvSecret util.FileSecret util.SecretFunc
+// 以及支持轮换宽限期的 util.RotatingSecret
+type SecretProvider = util.SecretProvider
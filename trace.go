@@ -0,0 +1,40 @@
+package kuaishou_server_api_sdk
+
+import (
+	"context"
+	"github.com/HeartGarlic/kuaishou-server-api-sdk/util"
+)
+
+// Tracer 链路追踪接口 见 util.Tracer
+// 每次接口调用开启一个span 其中每次尝试开启一个子span 默认的token管理每次刷新token也会开启一个span
+type Tracer = util.Tracer
+
+// Span 一段被追踪的操作 见 util.Span
+type Span = util.Span
+
+// tracingMiddleware 为每次尝试开启一个span 是 KuaiShou.do 中调用span的子span
+func tracingMiddleware(tracer Tracer) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			ctx, span := tracer.StartSpan(ctx, "kuaishou "+req.Endpoint+" attempt")
+			defer span.End()
+			span.SetAttribute("kuaishou.endpoint", req.Endpoint)
+			span.SetAttribute("kuaishou.attempt", req.Attempt)
+			outOrderNo, outRefundNo, outSettleNo := outNumbers(req.Params)
+			for key, val := range map[string]string{"kuaishou.out_order_no": outOrderNo, "kuaishou.out_refund_no": outRefundNo, "kuaishou.out_settle_no": outSettleNo} {
+				if val != "" {
+					span.SetAttribute(key, val)
+				}
+			}
+			res, err := next(ctx, req)
+			if res != nil {
+				span.SetAttribute("http.status_code", res.StatusCode)
+				span.SetAttribute("kuaishou.result", res.Result)
+			}
+			if err != nil {
+				span.RecordError(err)
+			}
+			return res, err
+		}
+	}
+}
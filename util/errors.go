@@ -0,0 +1,90 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// 快手接口的 result 错误码 见快手小程序服务端错误码文档
+// https://mp.kuaishou.com/docs/develop/server/code.html
+// 文档中其他的错误码可以通过 RegisterResultCode 归类
+const (
+	ResultSuccess      = 1         // 成功
+	ResultInvalidToken = 100200101 // access_token 无效
+	ResultExpiredToken = 100200102 // access_token 已过期
+)
+
+// 错误分类 可以通过 errors.Is(err, ErrInvalidToken) 判断
+// ErrSignatureFailed 由回调验签失败时返回 ErrDuplicateOutOrderNo ErrInsufficientBalance 文档没有给出对应的错误码
+// 需要按实际返回的 result 通过 RegisterResultCode 归类
+var (
+	ErrInvalidToken        = errors.New("kuaishou: invalid access_token")
+	ErrSignatureFailed     = errors.New("kuaishou: signature verification failed")
+	ErrDuplicateOutOrderNo = errors.New("kuaishou: duplicate out_order_no")
+	ErrInsufficientBalance = errors.New("kuaishou: insufficient balance")
+)
+
+// resultCodes result 错误码与错误分类的对应关系
+var (
+	resultCodesLock = new(sync.RWMutex)
+	resultCodes     = map[int]error{
+		ResultInvalidToken: ErrInvalidToken,
+		ResultExpiredToken: ErrInvalidToken,
+	}
+)
+
+// RegisterResultCode 把一个 result 错误码归类到某个错误分类 返回恢复原来归类的函数 可用于测试
+func RegisterResultCode(result int, class error) (restore func()) {
+	resultCodesLock.Lock()
+	defer resultCodesLock.Unlock()
+	old, ok := resultCodes[result]
+	resultCodes[result] = class
+	return func() {
+		resultCodesLock.Lock()
+		defer resultCodesLock.Unlock()
+		if ok {
+			resultCodes[result] = old
+			return
+		}
+		delete(resultCodes, result)
+	}
+}
+
+// KuaiShouError 快手接口返回的错误
+// http状态码不是200 或者 result 不是成功时返回
+type KuaiShouError struct {
+	Endpoint   string // 请求的接口 不包含query参数 避免泄露access_token
+	StatusCode int    // http状态码
+	Result     int    // 快手返回的 result
+	ErrorMsg   string // 快手返回的 error_msg
+	Body       []byte // 原始的返回内容
+}
+
+// Error 实现error接口
+func (e *KuaiShouError) Error() string {
+	if e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("kuaishou: http error : endpoint=%s , statusCode=%d", e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("kuaishou: endpoint=%s , result=%d , error_msg=%s", e.Endpoint, e.Result, e.ErrorMsg)
+}
+
+// Is 支持 errors.Is 判断错误分类
+func (e *KuaiShouError) Is(target error) bool {
+	resultCodesLock.RLock()
+	defer resultCodesLock.RUnlock()
+	class, ok := resultCodes[e.Result]
+	return ok && class == target
+}
+
+// NewResultError 根据快手返回的 result 构造一个错误
+func NewResultError(endpoint string, result int, errorMsg string, body []byte) *KuaiShouError {
+	return &KuaiShouError{
+		Endpoint:   endpoint,
+		StatusCode: http.StatusOK,
+		Result:     result,
+		ErrorMsg:   errorMsg,
+		Body:       body,
+	}
+}
@@ -2,43 +2,129 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
+// DefaultBaseApiHost 快手开放平台默认的api地址
+const DefaultBaseApiHost = "https://open.kuaishou.com"
+
+// JoinURL 拼接api地址与接口路径 host为空时使用 DefaultBaseApiHost
+// path 本身是完整地址时直接返回
+func JoinURL(host, path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if host == "" {
+		host = DefaultBaseApiHost
+	}
+	return strings.TrimRight(host, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// Doer 发送http请求的最小接口 *http.Client 已经实现了该接口
+// 可以替换成自定义的实现 用于设置代理 超时 或者在测试中模拟网络
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DefaultHTTPClient 未指定http客户端时使用的默认客户端
+var DefaultHTTPClient Doer = NewHTTPClient()
+
+// NewHTTPClient 实例化一个设置了超时与连接池大小的http客户端
+func NewHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   20,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
 // PostForm post form 数据请求
 func PostForm(uri string, obj url.Values) ([]byte, error) {
-	response, err := http.PostForm(uri, obj)
+	return PostFormWithContext(context.Background(), uri, obj)
+}
+
+// PostFormWithContext post form 数据请求 ctx取消或超时会中断请求
+func PostFormWithContext(ctx context.Context, uri string, obj url.Values) ([]byte, error) {
+	return PostFormWithClient(ctx, DefaultHTTPClient, uri, obj)
+}
+
+// PostFormWithClient 使用指定的http客户端 post form 数据请求 client为nil时使用 DefaultHTTPClient
+func PostFormWithClient(ctx context.Context, client Doer, uri string, obj url.Values) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(obj.Encode()))
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http get error : uri=%v , statusCode=%v", uri, response.StatusCode)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := do(client, request)
+	if err != nil {
+		return nil, err
 	}
-	return ioutil.ReadAll(response.Body)
+	return readResponse(request, response)
 }
 
 // PostJSON post json 数据请求
 func PostJSON(uri string, obj interface{}) ([]byte, error) {
+	return PostJSONWithContext(context.Background(), uri, obj)
+}
+
+// PostJSONWithContext post json 数据请求 ctx取消或超时会中断请求
+func PostJSONWithContext(ctx context.Context, uri string, obj interface{}) ([]byte, error) {
+	return PostJSONWithClient(ctx, DefaultHTTPClient, uri, obj)
+}
+
+// PostJSONWithClient 使用指定的http客户端 post json 数据请求 client为nil时使用 DefaultHTTPClient
+func PostJSONWithClient(ctx context.Context, client Doer, uri string, obj interface{}) ([]byte, error) {
 	marshal, err := json.Marshal(obj)
 	if err != nil {
 		return nil, err
 	}
-	response, err := http.Post(uri, "application/json;charset=utf-8", bytes.NewBuffer(marshal))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewBuffer(marshal))
 	if err != nil {
 		return nil, err
 	}
+	request.Header.Set("Content-Type", "application/json;charset=utf-8")
+	response, err := do(client, request)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(request, response)
+}
+
+// do 使用指定的客户端发送请求
+func do(client Doer, request *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	return client.Do(request)
+}
+
+// readResponse 读取返回值 http状态码不是200时返回 *KuaiShouError
+func readResponse(request *http.Request, response *http.Response) ([]byte, error) {
 	defer response.Body.Close()
 
+	body, err := ioutil.ReadAll(response.Body)
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http get error : uri=%v , statusCode=%v", uri, response.StatusCode)
+		return nil, &KuaiShouError{Endpoint: request.URL.Path, StatusCode: response.StatusCode, Body: body}
 	}
-	return ioutil.ReadAll(response.Body)
+	return body, err
 }
 
 // JsonStructToMap ...
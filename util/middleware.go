@@ -0,0 +1,137 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request 一次接口请求 中间件可以读取或修改
+type Request struct {
+	Endpoint string                 // 接口名称 即接口路径 如 /openapi/mp/developer/epay/query_order
+	URL      string                 // 完整的请求地址 包含query参数
+	Method   string                 // 请求方法 为空时使用POST 为GET时 Params 拼接到query参数中
+	Form     bool                   // 是否以form表单提交 否则提交json
+	Params   map[string]interface{} // 签名后的请求参数
+	Header   http.Header            // 额外的请求头
+	Attempt  int                    // 第几次尝试 从1开始
+	Out      interface{}            // 返回值解析的目标 可以为nil
+}
+
+// Response 接口的返回值
+type Response struct {
+	StatusCode int         // http状态码
+	Body       []byte      // 原始的返回内容
+	Result     int         // 快手返回的 result
+	ErrorMsg   string      // 快手返回的 error_msg
+	Value      interface{} // 解析后的返回值 即 Request.Out
+}
+
+// RoundTripFunc 执行一次请求
+// result 不为成功时同时返回 Response 与 *KuaiShouError
+type RoundTripFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware 中间件 包装 RoundTripFunc 在请求前后加入日志 监控 请求头等通用逻辑
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Chain 把中间件包装到 roundTrip 外层 第一个中间件最先执行
+func Chain(roundTrip RoundTripFunc, middlewares ...Middleware) RoundTripFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			roundTrip = middlewares[i](roundTrip)
+		}
+	}
+	return roundTrip
+}
+
+// Transport 返回使用client发送请求的 RoundTripFunc client为nil时使用 DefaultHTTPClient
+// 负责发送请求 解析返回值以及校验result
+func Transport(client Doer) RoundTripFunc {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		request, err := newRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		response, err := do(client, request)
+		if err != nil {
+			return nil, err
+		}
+		body, err := readResponse(request, response)
+		if err != nil {
+			return nil, err
+		}
+		res := &Response{StatusCode: response.StatusCode, Body: body, Value: req.Out}
+		// 先解析公共字段 再解析到具体的返回值
+		var common struct {
+			Result   int    `json:"result"`
+			ErrorMsg string `json:"error_msg"`
+		}
+		if err = json.Unmarshal(body, &common); err != nil {
+			return res, err
+		}
+		res.Result, res.ErrorMsg = common.Result, common.ErrorMsg
+		if req.Out != nil {
+			if err = json.Unmarshal(body, req.Out); err != nil {
+				return res, err
+			}
+		}
+		if res.Result != ResultSuccess {
+			return res, NewResultError(req.Endpoint, res.Result, res.ErrorMsg, body)
+		}
+		return res, nil
+	}
+}
+
+// newRequest 根据 Request 构造http请求
+func newRequest(ctx context.Context, req *Request) (*http.Request, error) {
+	var request *http.Request
+	switch {
+	case req.Method == http.MethodGet:
+		parse, err := url.Parse(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		query := parse.Query()
+		for key, values := range formValues(req.Params) {
+			query[key] = values
+		}
+		parse.RawQuery = query.Encode()
+		if request, err = http.NewRequestWithContext(ctx, http.MethodGet, parse.String(), nil); err != nil {
+			return nil, err
+		}
+	case req.Form:
+		var err error
+		if request, err = http.NewRequestWithContext(ctx, http.MethodPost, req.URL, strings.NewReader(formValues(req.Params).Encode())); err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	default:
+		marshal, err := json.Marshal(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		if request, err = http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewBuffer(marshal)); err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json;charset=utf-8")
+	}
+	for key, values := range req.Header {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	return request, nil
+}
+
+// formValues 把请求参数转换为 url.Values
+func formValues(params map[string]interface{}) url.Values {
+	values := url.Values{}
+	for key, val := range params {
+		values.Set(key, fmt.Sprintf("%v", val))
+	}
+	return values
+}
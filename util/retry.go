@@ -0,0 +1,114 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy 失败重试策略 采用指数退避并加入随机抖动
+// 为nil时不重试
+type RetryPolicy struct {
+	MaxAttempts    int                        // 最大尝试次数 包含第一次请求 小于等于1时不重试
+	InitialBackoff time.Duration              // 第一次重试前的等待时间
+	MaxBackoff     time.Duration              // 等待时间的上限 为0时不限制
+	Multiplier     float64                    // 每次重试等待时间的增长倍数 小于1时按2处理
+	Jitter         float64                    // 随机抖动的比例 取值 0-1 0.2表示在等待时间上下浮动20%
+	Retryable      func(err error) bool       // 判断错误是否可以重试 为空时使用 IsRetryable
+	OnAttempt      func(attempt RetryAttempt) // 每次尝试结束后回调 可用于记录日志或监控
+}
+
+// RetryAttempt 一次尝试的结果
+type RetryAttempt struct {
+	Endpoint  string        // 请求的接口
+	Attempt   int           // 第几次尝试 从1开始
+	Err       error         // 本次尝试的错误 成功时为nil
+	WillRetry bool          // 是否会继续重试
+	Backoff   time.Duration // 下一次重试前的等待时间
+}
+
+// NewRetryPolicy 实例化一个默认的重试策略 最多请求3次 等待时间从100ms开始 最长2s
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Do 执行fn 失败且允许重试时按策略等待后再次执行
+// idempotent 为false时表示请求重复执行会产生副作用 不会重试
+func (p *RetryPolicy) Do(ctx context.Context, endpoint string, idempotent bool, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		result := RetryAttempt{Endpoint: endpoint, Attempt: attempt, Err: err}
+		if err != nil && p != nil && idempotent && attempt < p.MaxAttempts && p.retryable(err) && ctx.Err() == nil {
+			result.WillRetry = true
+			result.Backoff = p.backoff(attempt)
+		}
+		if p != nil && p.OnAttempt != nil {
+			p.OnAttempt(result)
+		}
+		if !result.WillRetry {
+			return err
+		}
+		timer := time.NewTimer(result.Backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable 判断错误是否可以重试
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff 计算第attempt次失败后的等待时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.Jitter > 0 {
+		delta := backoff * math.Min(p.Jitter, 1)
+		backoff = backoff - delta + rand.Float64()*2*delta
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryable 判断是否是可以重试的临时错误
+// 包括 http 5xx 429 连接被重置 连接被拒绝 超时 以及读取返回值时连接中断
+// context 被取消 以及快手返回的业务错误不会重试
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var kuaiShouError *KuaiShouError
+	if errors.As(err, &kuaiShouError) {
+		return kuaiShouError.StatusCode >= http.StatusInternalServerError || kuaiShouError.StatusCode == http.StatusTooManyRequests
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
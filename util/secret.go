@@ -0,0 +1,193 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEmptySecret 没有获取到密钥
+var ErrEmptySecret = errors.New("kuaishou: app secret is empty")
+
+// SecretProvider 提供 AppSecret 每次签名 获取token 验证回调时调用 轮换密钥不需要重启服务
+type SecretProvider interface {
+	// Secret 当前使用的密钥 用于签名与获取token
+	Secret(ctx context.Context) (string, error)
+	// Secrets 验证回调签名时接受的所有密钥 第一个为当前密钥 轮换期间还包含旧密钥
+	Secrets(ctx context.Context) ([]string, error)
+}
+
+// StaticSecret 固定不变的密钥 未设置 SecretProvider 时使用 AppSecret
+type StaticSecret string
+
+// Secret 当前使用的密钥
+func (s StaticSecret) Secret(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// Secrets 验证回调签名时接受的密钥
+func (s StaticSecret) Secrets(ctx context.Context) ([]string, error) {
+	return []string{string(s)}, nil
+}
+
+// SecretFunc 自定义获取密钥的方法 如从配置中心或者密钥管理服务读取
+type SecretFunc func(ctx context.Context) (string, error)
+
+// Secret 当前使用的密钥
+func (f SecretFunc) Secret(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// Secrets 验证回调签名时接受的密钥
+func (f SecretFunc) Secrets(ctx context.Context) ([]string, error) {
+	secret, err := f(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []string{secret}, nil
+}
+
+// EnvSecret 每次从环境变量读取密钥
+type EnvSecret struct {
+	Name         string // 当前密钥的环境变量名
+	PreviousName string // 旧密钥的环境变量名 不为空时验证回调签名也接受旧密钥
+}
+
+// NewEnvSecret 实例化一个从环境变量读取的密钥
+func NewEnvSecret(name string) *EnvSecret {
+	return &EnvSecret{Name: name}
+}
+
+// Secret 当前使用的密钥
+func (e *EnvSecret) Secret(ctx context.Context) (string, error) {
+	secret := os.Getenv(e.Name)
+	if secret == "" {
+		return "", fmt.Errorf("%w : env=%s", ErrEmptySecret, e.Name)
+	}
+	return secret, nil
+}
+
+// Secrets 验证回调签名时接受的密钥
+func (e *EnvSecret) Secrets(ctx context.Context) ([]string, error) {
+	secret, err := e.Secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secrets := []string{secret}
+	if previous := os.Getenv(e.PreviousName); e.PreviousName != "" && previous != "" && previous != secret {
+		secrets = append(secrets, previous)
+	}
+	return secrets, nil
+}
+
+// FileSecret 从文件读取密钥 文件修改后自动重新读取 适合挂载的 kubernetes secret
+type FileSecret struct {
+	Path    string // 密钥文件的路径 内容首尾的空白会被去掉
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	secret  string
+}
+
+// NewFileSecret 实例化一个从文件读取的密钥
+func NewFileSecret(path string) *FileSecret {
+	return &FileSecret{Path: path}
+}
+
+// Secret 当前使用的密钥
+func (f *FileSecret) Secret(ctx context.Context) (string, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.secret == "" || !info.ModTime().Equal(f.modTime) || info.Size() != f.size {
+		content, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return "", err
+		}
+		f.secret, f.modTime, f.size = strings.TrimSpace(string(content)), info.ModTime(), info.Size()
+	}
+	if f.secret == "" {
+		return "", fmt.Errorf("%w : file=%s", ErrEmptySecret, f.Path)
+	}
+	return f.secret, nil
+}
+
+// Secrets 验证回调签名时接受的密钥
+func (f *FileSecret) Secrets(ctx context.Context) ([]string, error) {
+	secret, err := f.Secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []string{secret}, nil
+}
+
+// RotatingSecret 记住轮换前的密钥 在宽限期内验证回调签名时同时接受新旧密钥
+// 快手在密钥轮换前发出的回调 重试时仍然使用旧密钥签名
+// 发现的旧密钥只保存在内存中 进程在宽限期内重启后丢失 需要跨重启时设置 Previous
+type RotatingSecret struct {
+	Provider    SecretProvider // 实际提供密钥的 SecretProvider
+	GracePeriod time.Duration  // 发现密钥变化后 旧密钥继续有效的时间
+	Previous    string         // 已知的旧密钥 第一次读取密钥后的宽限期内接受 为空时只接受运行中发现的旧密钥
+	lock        sync.Mutex
+	current     string
+	previous    string
+	rotatedAt   time.Time
+}
+
+// NewRotatingSecret 实例化一个支持轮换宽限期的密钥
+func NewRotatingSecret(provider SecretProvider, gracePeriod time.Duration) *RotatingSecret {
+	return &RotatingSecret{Provider: provider, GracePeriod: gracePeriod}
+}
+
+// Secret 当前使用的密钥
+func (r *RotatingSecret) Secret(ctx context.Context) (string, error) {
+	secret, err := r.Provider.Secret(ctx)
+	if err != nil {
+		return "", err
+	}
+	r.observe(secret)
+	return secret, nil
+}
+
+// Secrets 验证回调签名时接受的密钥 宽限期内包含旧密钥
+func (r *RotatingSecret) Secrets(ctx context.Context) ([]string, error) {
+	secrets, err := r.Provider.Secrets(ctx)
+	if err != nil || len(secrets) == 0 {
+		return secrets, err
+	}
+	r.observe(secrets[0])
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.previous == "" || time.Since(r.rotatedAt) >= r.GracePeriod {
+		return secrets, nil
+	}
+	for _, secret := range secrets {
+		if secret == r.previous {
+			return secrets, nil
+		}
+	}
+	return append(secrets, r.previous), nil
+}
+
+// observe 记录当前密钥 发现变化时保存旧密钥
+func (r *RotatingSecret) observe(secret string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if secret == r.current {
+		return
+	}
+	if r.current != "" {
+		r.previous, r.rotatedAt = r.current, time.Now()
+	} else if r.Previous != "" && r.Previous != secret {
+		r.previous, r.rotatedAt = r.Previous, time.Now()
+	}
+	r.current = secret
+}
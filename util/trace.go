@@ -0,0 +1,35 @@
+package util
+
+import (
+	"context"
+)
+
+// Tracer 链路追踪接口 sdk不依赖具体的追踪系统 由使用方桥接
+type Tracer interface {
+	// StartSpan 以ctx中的span为父级开启一个新的span 返回携带新span的ctx
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 一段被追踪的操作
+type Span interface {
+	SetAttribute(key string, value interface{}) // 设置属性
+	RecordError(err error)                      // 记录错误
+	End()                                       // 结束span
+}
+
+// StartSpan tracer为nil时返回不做任何操作的span
+func StartSpan(ctx context.Context, tracer Tracer, name string) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return tracer.StartSpan(ctx, name)
+}
+
+// noopSpan 不做任何操作的span
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) End() {}